@@ -0,0 +1,227 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+	"github.com/garnaud/hackathon-2018/waste"
+)
+
+// Config of the scrapper. It is read from a json file (-config) and each
+// value can be overridden by the command line flags
+type Config struct {
+	KeywordsFile    string                  `json:"keywordsFile"`    // file with one query per line
+	Device          string                  `json:"device"`          // device of the user agent: desktop or mobile
+	Parser          string                  `json:"parser"`          // name of the SERP layout parser, "auto" tries all of them
+	SaveDir         string                  `json:"saveDir"`         // directory where fetched pages are saved for replay
+	Output          string                  `json:"output"`          // format of the results on stdout: text, json or jsonl
+	GoogleDomain    string                  `json:"googleDomain"`    // google country domain (google.fr, google.de...)
+	Language        string                  `json:"hl"`              // interface language of google (hl parameter)
+	Country         string                  `json:"gl"`              // country of the results (gl parameter)
+	Location        string                  `json:"location"`        // canonical name of the city of the search, e.g. "Lyon,Auvergne-Rhone-Alpes,France"
+	Watch           []scraper.WatchedDomain `json:"watch"`           // domains monitored in SEA and SEO results
+	Sinks           []sinkConfig            `json:"sinks"`           // where metrics are sent
+	Prefix          string                  `json:"prefix"`          // prefix of the metrics
+	Graphite        graphiteConfig          `json:"graphite"`        // graphite endpoint
+	Depth           int                     `json:"depth"`           // number of result pages scraped for each keywords
+	Workers         int                     `json:"workers"`         // number of keywords scraped at the same time
+	Delay           duration                `json:"delay"`           // delay of a worker between two requests, e.g. "5s"
+	Jitter          duration                `json:"jitter"`          // maximum random delay added to the delay
+	RPM             int                     `json:"rpm"`             // requests per minute of the whole process, 0 for no limit
+	Retries         int                     `json:"retries"`         // retries of a scrape blocked by google
+	Backoff         duration                `json:"backoff"`         // wait before the first retry, doubled at each retry
+	Proxies         string                  `json:"proxies"`         // file of the proxies of the requests, one per line
+	ProxyStrategy   string                  `json:"proxyStrategy"`   // how the proxy of a request is picked: round-robin or random
+	ProxyQuarantine duration                `json:"proxyQuarantine"` // time an unhealthy proxy is left out
+	DB              string                  `json:"db"`              // database file where every result is kept, see the history command
+	Rules           string                  `json:"rules"`           // json file of the alerting rules and their notifiers
+	Waste           waste.Thresholds        `json:"waste"`           // waste thresholds of the watched domains without their own
+}
+
+// defaultWatch is used when neither the config file nor the flags define watched domains
+var defaultWatch = []scraper.WatchedDomain{{Domain: "www.oui.sncf", Siblings: []string{"www.sncf.com"}}}
+
+// parseConfig loads the config file given by -config, then applies the flags
+// of args. Command specific flags must be defined on fs before
+func parseConfig(fs *flag.FlagSet, args []string) (*Config, error) {
+	cfg := &Config{
+		Device:          os.Getenv("DEVICE"),
+		Parser:          "auto",
+		GoogleDomain:    "google.com",
+		Output:          "text",
+		Prefix:          "DT.hackhaton.2018.adwords",
+		Graphite:        defaultGraphite,
+		Waste:           waste.DefaultThresholds,
+		Depth:           1,
+		Workers:         1,
+		Retries:         2,
+		Backoff:         duration(30 * time.Second),
+		ProxyStrategy:   "round-robin",
+		ProxyQuarantine: duration(10 * time.Minute),
+	}
+	if path := configPath(args); path != "" {
+		file, err := os.Open(path)
+		if err != nil {
+			return nil, err
+		}
+		defer file.Close()
+		if err := json.NewDecoder(file).Decode(cfg); err != nil {
+			return nil, errors.New("can't read config file " + path + ": " + err.Error())
+		}
+	}
+
+	fs.String("config", "", "json config file, flags override its values")
+	fs.StringVar(&cfg.KeywordsFile, "keywords-file", cfg.KeywordsFile, "file with one query per line (blank lines and lines starting with '#' are ignored)")
+	fs.StringVar(&cfg.Device, "device", cfg.Device, "device of the user agent: desktop or mobile (default from DEVICE environment variable)")
+	fs.StringVar(&cfg.Parser, "parser", cfg.Parser, "SERP layout parser: "+strings.Join(scraper.ParserNames(), ", "))
+	fs.StringVar(&cfg.Output, "output", cfg.Output, "format of the results on stdout: "+strings.Join(outputFormats, ", ")+" (logs go to stderr)")
+	fs.StringVar(&cfg.SaveDir, "save-dir", cfg.SaveDir, "directory where every fetched page is saved (html and json sidecar) for replay")
+	fs.StringVar(&cfg.GoogleDomain, "google-domain", cfg.GoogleDomain, "google country domain requested (google.fr, google.de...)")
+	fs.StringVar(&cfg.Language, "hl", cfg.Language, "interface language of google (hl parameter)")
+	fs.StringVar(&cfg.Country, "gl", cfg.Country, "country of the results (gl parameter)")
+	fs.StringVar(&cfg.Location, "location", cfg.Location, "canonical name of the city of the search (uule parameter), e.g. 'Lyon,Auvergne-Rhone-Alpes,France'")
+	fs.Var(&watchFlag{cfg: cfg}, "watch", "watched domain with its optional siblings: 'domain[=sibling,sibling]' (repeatable)")
+	fs.Var(&sinkFlag{cfg: cfg}, "sink", "metrics sink 'type[=address]', type in "+strings.Join(sinkTypes, ", ")+" (repeatable, default graphite and csv)")
+	fs.StringVar(&cfg.Prefix, "prefix", cfg.Prefix, "prefix of the metrics")
+	fs.StringVar(&cfg.Graphite.Host, "graphite-host", cfg.Graphite.Host, "graphite host")
+	fs.IntVar(&cfg.Graphite.Port, "graphite-port", cfg.Graphite.Port, "graphite port")
+	fs.StringVar(&cfg.Graphite.Protocol, "graphite-protocol", cfg.Graphite.Protocol, "graphite protocol: tcp or udp")
+	fs.StringVar(&cfg.Graphite.Spool, "graphite-spool", cfg.Graphite.Spool, "file where metrics are kept while graphite is unavailable, replayed on the next run")
+	fs.StringVar(&cfg.DB, "db", cfg.DB, "database file where every result is kept for the history command")
+	fs.StringVar(&cfg.Rules, "rules", cfg.Rules, "json file of the alerting rules checked on every result, and their notifiers")
+	fs.IntVar(&cfg.Waste.MaxIntervening, "waste-max-intervening", cfg.Waste.MaxIntervening, "competitor results allowed between an ad and the organic result of a watched domain for a waste")
+	fs.IntVar(&cfg.Waste.MaxOrganicPosition, "waste-max-organic-position", cfg.Waste.MaxOrganicPosition, "lowest position of the organic result of a watched domain for a waste, -1 for any")
+	fs.IntVar(&cfg.Workers, "workers", cfg.Workers, "number of keywords scraped at the same time")
+	fs.Var(&cfg.Delay, "delay", "delay of a worker between two requests to google, e.g. 5s")
+	fs.Var(&cfg.Jitter, "jitter", "maximum random delay added to --delay, e.g. 3s")
+	fs.IntVar(&cfg.RPM, "rpm", cfg.RPM, "requests per minute to google of the whole process, whatever the workers (0 for no limit)")
+	fs.IntVar(&cfg.Retries, "retries", cfg.Retries, "retries of a scrape blocked by google (captcha, 429, 503), each with another user agent")
+	fs.Var(&cfg.Backoff, "backoff", "wait before the first retry of a blocked scrape, doubled at each retry")
+	fs.StringVar(&cfg.Proxies, "proxies", cfg.Proxies, "file of the http or socks5 proxies of the requests, one per line (default from the PROXIES environment variable, comma separated)")
+	fs.StringVar(&cfg.ProxyStrategy, "proxy-strategy", cfg.ProxyStrategy, "how the proxy of a request is picked: "+strings.Join(scraper.ProxyStrategies, ", "))
+	fs.Var(&cfg.ProxyQuarantine, "proxy-quarantine", "time a proxy too often blocked or failing is left out")
+	fs.IntVar(&cfg.Depth, "depth", cfg.Depth, "number of result pages scraped for each keywords (start= pagination), organic positions are absolute across pages")
+	if err := fs.Parse(args); err != nil {
+		return nil, err
+	}
+
+	if cfg.Device == "" {
+		cfg.Device = "desktop"
+	}
+	if cfg.Device != "desktop" && cfg.Device != "mobile" {
+		return nil, errors.New("unknown device " + cfg.Device + " (available: desktop, mobile)")
+	}
+	if cfg.Depth < 1 {
+		return nil, fmt.Errorf("invalid depth %d, at least 1 page is scraped", cfg.Depth)
+	}
+	if cfg.Workers < 1 {
+		return nil, fmt.Errorf("invalid number of workers %d", cfg.Workers)
+	}
+	if cfg.Delay < 0 || cfg.Jitter < 0 || cfg.RPM < 0 || cfg.Retries < 0 || cfg.Backoff < 0 {
+		return nil, errors.New("delay, jitter, rpm, retries and backoff can't be negative")
+	}
+	if len(cfg.Watch) == 0 {
+		cfg.Watch = append([]scraper.WatchedDomain{}, defaultWatch...)
+	}
+	for i := range cfg.Watch {
+		if cfg.Watch[i].Waste == nil {
+			thresholds := cfg.Waste
+			cfg.Watch[i].Waste = &thresholds
+		}
+	}
+	if len(cfg.Sinks) == 0 {
+		cfg.Sinks = defaultSinks
+	}
+	cfg.GoogleDomain = strings.TrimPrefix(strings.ToLower(cfg.GoogleDomain), "www.")
+	return cfg, nil
+}
+
+// configPath looks for the -config flag before the flags are parsed
+func configPath(args []string) string {
+	for i, arg := range args {
+		name := strings.TrimLeft(arg, "-")
+		if name == arg {
+			continue
+		}
+		if name == "config" && i+1 < len(args) {
+			return args[i+1]
+		}
+		if strings.HasPrefix(name, "config=") {
+			return strings.TrimPrefix(name, "config=")
+		}
+	}
+	return ""
+}
+
+// duration is a time.Duration written "5s" in the config file and the flags
+type duration time.Duration
+
+func (d duration) String() string {
+	return time.Duration(d).String()
+}
+
+func (d *duration) Set(value string) error {
+	parsed, err := time.ParseDuration(value)
+	if err != nil {
+		return err
+	}
+	*d = duration(parsed)
+	return nil
+}
+
+func (d *duration) UnmarshalJSON(b []byte) error {
+	var value string
+	if err := json.Unmarshal(b, &value); err != nil {
+		return errors.New("duration must be a string like \"5s\": " + string(b))
+	}
+	return d.Set(value)
+}
+
+func (d duration) MarshalJSON() ([]byte, error) {
+	return json.Marshal(d.String())
+}
+
+// watchFlag parses a repeatable -watch flag. The first occurrence replaces
+// the domains of the config file
+type watchFlag struct {
+	cfg *Config
+	set bool
+}
+
+func (f *watchFlag) String() string {
+	if f == nil || f.cfg == nil {
+		return ""
+	}
+	domains := make([]string, 0, len(f.cfg.Watch))
+	for _, w := range f.cfg.Watch {
+		domains = append(domains, w.Domain)
+	}
+	return strings.Join(domains, ",")
+}
+
+func (f *watchFlag) Set(value string) error {
+	if !f.set {
+		f.cfg.Watch = nil
+		f.set = true
+	}
+	parts := strings.SplitN(value, "=", 2)
+	w := scraper.WatchedDomain{Domain: strings.TrimSpace(parts[0])}
+	if w.Domain == "" {
+		return errors.New("empty watched domain")
+	}
+	if len(parts) == 2 {
+		for _, sibling := range strings.Split(parts[1], ",") {
+			if sibling = strings.TrimSpace(sibling); sibling != "" {
+				w.Siblings = append(w.Siblings, sibling)
+			}
+		}
+	}
+	f.cfg.Watch = append(f.cfg.Watch, w)
+	return nil
+}
@@ -0,0 +1,142 @@
+package main
+
+import (
+	"errors"
+	"fmt"
+	"strconv"
+	"strings"
+	"time"
+)
+
+// cronSchedule is a parsed cron expression: "minute hour day-of-month month
+// day-of-week". Each field accepts *, numbers, ranges (1-5), steps (*/15,
+// 0-30/10) and lists (1,15,30)
+type cronSchedule struct {
+	minutes     map[int]bool
+	hours       map[int]bool
+	daysOfMonth map[int]bool
+	months      map[int]bool
+	daysOfWeek  map[int]bool
+	anyDay      [2]bool // day-of-month, day-of-week fields are "*"
+}
+
+// cronFields are the bounds of each field
+var cronFields = []struct {
+	name     string
+	min, max int
+}{
+	{"minute", 0, 59},
+	{"hour", 0, 23},
+	{"day of month", 1, 31},
+	{"month", 1, 12},
+	{"day of week", 0, 6},
+}
+
+// parseCron parses a 5 fields cron expression
+func parseCron(expr string) (*cronSchedule, error) {
+	fields := strings.Fields(expr)
+	if len(fields) != len(cronFields) {
+		return nil, fmt.Errorf("cron expression %q must have %d fields", expr, len(cronFields))
+	}
+	sets := make([]map[int]bool, len(fields))
+	for i, field := range fields {
+		set, err := parseCronField(field, cronFields[i].min, cronFields[i].max)
+		if err != nil {
+			return nil, fmt.Errorf("invalid %s in cron expression %q: %v", cronFields[i].name, expr, err)
+		}
+		sets[i] = set
+	}
+	// 7 is sunday too
+	if sets[4][7] {
+		sets[4][0] = true
+	}
+	return &cronSchedule{
+		minutes:     sets[0],
+		hours:       sets[1],
+		daysOfMonth: sets[2],
+		months:      sets[3],
+		daysOfWeek:  sets[4],
+		anyDay:      [2]bool{fields[2] == "*", fields[4] == "*"},
+	}, nil
+}
+
+// parseCronField returns the values matched by a field
+func parseCronField(field string, min, max int) (map[int]bool, error) {
+	set := make(map[int]bool)
+	for _, part := range strings.Split(field, ",") {
+		step := 1
+		if i := strings.Index(part, "/"); i >= 0 {
+			var err error
+			if step, err = strconv.Atoi(part[i+1:]); err != nil || step <= 0 {
+				return nil, errors.New("invalid step in " + part)
+			}
+			part = part[:i]
+		}
+		from, to := min, max
+		if part != "*" {
+			bounds := strings.SplitN(part, "-", 2)
+			var err error
+			if from, err = strconv.Atoi(bounds[0]); err != nil {
+				return nil, errors.New("invalid value " + part)
+			}
+			to = from
+			if len(bounds) == 2 {
+				if to, err = strconv.Atoi(bounds[1]); err != nil {
+					return nil, errors.New("invalid range " + part)
+				}
+			} else if step > 1 {
+				// "5/15" means from 5 to the max every 15
+				to = max
+			}
+		}
+		// day of week accepts 7 for sunday
+		if from < min || to > max && !(max == 6 && to == 7) || from > to {
+			return nil, fmt.Errorf("%s out of bounds %d-%d", part, min, max)
+		}
+		for v := from; v <= to; v += step {
+			set[v] = true
+		}
+	}
+	return set, nil
+}
+
+// matchDay returns true if the day of t is scheduled. As in cron, when both
+// day fields are restricted, a day matching one of them is scheduled
+func (c *cronSchedule) matchDay(t time.Time) bool {
+	dom := c.daysOfMonth[t.Day()]
+	dow := c.daysOfWeek[int(t.Weekday())]
+	switch {
+	case c.anyDay[0] && c.anyDay[1]:
+		return true
+	case c.anyDay[0]:
+		return dow
+	case c.anyDay[1]:
+		return dom
+	default:
+		return dom || dow
+	}
+}
+
+// next returns the first scheduled time after t
+func (c *cronSchedule) next(t time.Time) time.Time {
+	t = t.Truncate(time.Minute).Add(time.Minute)
+	// a schedule matches at least once every 4 years (29th of february)
+	limit := t.AddDate(5, 0, 0)
+	for t.Before(limit) {
+		if !c.months[int(t.Month())] || !c.matchDay(t) {
+			t = time.Date(t.Year(), t.Month(), t.Day(), 0, 0, 0, 0, t.Location()).AddDate(0, 0, 1)
+			continue
+		}
+		if !c.hours[t.Hour()] {
+			// Truncate rounds against UTC, wrong in zones with half hour offsets
+			t = time.Date(t.Year(), t.Month(), t.Day(), t.Hour()+1, 0, 0, 0, t.Location())
+			continue
+		}
+		if !c.minutes[t.Minute()] {
+			t = t.Add(time.Minute)
+			continue
+		}
+		return t
+	}
+	return time.Time{}
+}
@@ -0,0 +1,131 @@
+package main
+
+import (
+	"testing"
+	"time"
+)
+
+// location loads a time zone or fails the test
+func location(t *testing.T, name string) *time.Location {
+	loc, err := time.LoadLocation(name)
+	if err != nil {
+		t.Fatalf("can't load %s: %v", name, err)
+	}
+	return loc
+}
+
+func TestParseCron(t *testing.T) {
+	tests := []struct {
+		expr  string
+		valid bool
+	}{
+		{"* * * * *", true},
+		{"*/15 8-18 * * 1-5", true},
+		{"0,30 6 1,15 * 7", true},
+		{"5/10 * * 2 *", true},
+		{"* * * *", false},
+		{"60 * * * *", false},
+		{"* 24 * * *", false},
+		{"* * 0 * *", false},
+		{"* * * 13 *", false},
+		{"* * * * 8", false},
+		{"*/0 * * * *", false},
+		{"5-1 * * * *", false},
+		{"a * * * *", false},
+	}
+	for _, test := range tests {
+		_, err := parseCron(test.expr)
+		if valid := err == nil; valid != test.valid {
+			t.Errorf("parseCron(%q) error = %v, want valid %v", test.expr, err, test.valid)
+		}
+	}
+}
+
+func TestCronNext(t *testing.T) {
+	kolkata := location(t, "Asia/Kolkata")
+	paris := location(t, "Europe/Paris")
+	tests := []struct {
+		name string
+		expr string
+		from time.Time
+		want time.Time
+	}{
+		{
+			name: "next minute",
+			expr: "* * * * *",
+			from: time.Date(2018, 5, 4, 10, 20, 30, 0, time.UTC),
+			want: time.Date(2018, 5, 4, 10, 21, 0, 0, time.UTC),
+		},
+		{
+			name: "strictly after",
+			expr: "20 10 * * *",
+			from: time.Date(2018, 5, 4, 10, 20, 0, 0, time.UTC),
+			want: time.Date(2018, 5, 5, 10, 20, 0, 0, time.UTC),
+		},
+		{
+			name: "step",
+			expr: "*/15 * * * *",
+			from: time.Date(2018, 5, 4, 10, 31, 0, 0, time.UTC),
+			want: time.Date(2018, 5, 4, 10, 45, 0, 0, time.UTC),
+		},
+		{
+			name: "half hour offset",
+			expr: "0 11 * * *",
+			from: time.Date(2018, 5, 4, 9, 0, 0, 0, kolkata),
+			want: time.Date(2018, 5, 4, 11, 0, 0, 0, kolkata),
+		},
+		{
+			name: "half hour offset next day",
+			expr: "30 8 * * *",
+			from: time.Date(2018, 5, 4, 23, 10, 0, 0, kolkata),
+			want: time.Date(2018, 5, 5, 8, 30, 0, 0, kolkata),
+		},
+		{
+			name: "weekdays",
+			expr: "0 9 * * 1-5",
+			from: time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC), // friday
+			want: time.Date(2018, 5, 7, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "sunday as 7",
+			expr: "0 9 * * 7",
+			from: time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC),
+			want: time.Date(2018, 5, 6, 9, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "day of month or day of week",
+			expr: "0 0 15 * 1",
+			from: time.Date(2018, 5, 8, 0, 0, 0, 0, time.UTC), // tuesday
+			want: time.Date(2018, 5, 14, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "29th of february",
+			expr: "0 0 29 2 *",
+			from: time.Date(2018, 5, 4, 0, 0, 0, 0, time.UTC),
+			want: time.Date(2020, 2, 29, 0, 0, 0, 0, time.UTC),
+		},
+		{
+			name: "daylight saving time gap",
+			expr: "30 * * * *",
+			from: time.Date(2018, 3, 25, 1, 45, 0, 0, paris),
+			want: time.Date(2018, 3, 25, 3, 30, 0, 0, paris),
+		},
+		{
+			name: "never",
+			expr: "0 0 31 2 *",
+			from: time.Date(2018, 5, 4, 0, 0, 0, 0, time.UTC),
+			want: time.Time{},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			c, err := parseCron(test.expr)
+			if err != nil {
+				t.Fatalf("parseCron(%q): %v", test.expr, err)
+			}
+			if got := c.next(test.from); !got.Equal(test.want) {
+				t.Errorf("next(%v) = %v, want %v", test.from, got, test.want)
+			}
+		})
+	}
+}
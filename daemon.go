@@ -0,0 +1,287 @@
+package main
+
+import (
+	"context"
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"math/rand"
+	"os"
+	"os/signal"
+	"sort"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// campaign is a set of keywords scraped together on a schedule, for each
+// device and location
+type campaign struct {
+	Name         string   `json:"name"`
+	Keywords     []string `json:"keywords"`
+	KeywordsFile string   `json:"keywordsFile"` // added to keywords
+	Devices      []string `json:"devices"`      // device of the config by default
+	Locations    []string `json:"locations"`    // location of the config by default, "" for no location
+	Interval     string   `json:"interval"`     // e.g. "1h", the first run starts immediately
+	Cron         string   `json:"cron"`         // e.g. "0 */2 * * *", instead of interval
+	Jitter       string   `json:"jitter"`       // window over which the scrapes of a run are spread, e.g. "2m"
+
+	interval time.Duration
+	jitter   time.Duration
+	cron     *cronSchedule
+}
+
+// campaignFile is the json definition of the campaigns of the daemon
+type campaignFile struct {
+	Campaigns []*campaign `json:"campaigns"`
+}
+
+// job is one scrape of a campaign
+type job struct {
+	campaign string
+	keywords string
+	device   string
+	location string
+}
+
+// loadCampaigns reads and checks the campaigns of a file
+func loadCampaigns(path string, cfg *Config) ([]*campaign, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	definition := campaignFile{}
+	if err := json.NewDecoder(file).Decode(&definition); err != nil {
+		return nil, errors.New("can't read campaign file " + path + ": " + err.Error())
+	}
+	if len(definition.Campaigns) == 0 {
+		return nil, errors.New("no campaign in " + path)
+	}
+
+	for i, c := range definition.Campaigns {
+		if c.Name == "" {
+			c.Name = fmt.Sprintf("campaign-%d", i)
+		}
+		if c.KeywordsFile != "" {
+			keywordsList, err := readKeywords(c.KeywordsFile)
+			if err != nil {
+				return nil, err
+			}
+			c.Keywords = append(c.Keywords, keywordsList...)
+		}
+		if len(c.Keywords) == 0 {
+			return nil, errors.New("campaign " + c.Name + " has no keywords")
+		}
+		if len(c.Devices) == 0 {
+			c.Devices = []string{cfg.Device}
+		}
+		for _, device := range c.Devices {
+			if device != "desktop" && device != "mobile" {
+				return nil, errors.New("campaign " + c.Name + ": unknown device " + device)
+			}
+		}
+		if len(c.Locations) == 0 {
+			c.Locations = []string{cfg.Location}
+		}
+		switch {
+		case c.Cron != "":
+			if c.cron, err = parseCron(c.Cron); err != nil {
+				return nil, errors.New("campaign " + c.Name + ": " + err.Error())
+			}
+			if c.cron.next(time.Now()).IsZero() {
+				return nil, errors.New("campaign " + c.Name + ": cron expression " + c.Cron + " never matches")
+			}
+		case c.Interval != "":
+			if c.interval, err = time.ParseDuration(c.Interval); err != nil || c.interval <= 0 {
+				return nil, errors.New("campaign " + c.Name + ": invalid interval " + c.Interval)
+			}
+		default:
+			return nil, errors.New("campaign " + c.Name + " has neither interval nor cron")
+		}
+		if c.Jitter != "" {
+			if c.jitter, err = time.ParseDuration(c.Jitter); err != nil || c.jitter < 0 {
+				return nil, errors.New("campaign " + c.Name + ": invalid jitter " + c.Jitter)
+			}
+		}
+	}
+	return definition.Campaigns, nil
+}
+
+// jobs returns the scrapes of one run of the campaign
+func (c *campaign) jobs() []job {
+	jobs := make([]job, 0, len(c.Keywords)*len(c.Devices)*len(c.Locations))
+	for _, keywords := range c.Keywords {
+		for _, device := range c.Devices {
+			for _, location := range c.Locations {
+				jobs = append(jobs, job{campaign: c.Name, keywords: keywords, device: device, location: location})
+			}
+		}
+	}
+	return jobs
+}
+
+// schedule sends the jobs of the campaign at each run until ctx is done
+func (c *campaign) schedule(ctx context.Context, jobs chan<- job) {
+	var last time.Time
+	for {
+		now := time.Now()
+		next := now
+		if c.cron != nil {
+			next = c.cron.next(now)
+		} else if !last.IsZero() {
+			next = last.Add(c.interval)
+		}
+		log.Printf("campaign %s: next run at %s", c.Name, next.Format(time.RFC3339))
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(time.Until(next)):
+		}
+		last = next
+
+		// jitter spreads the scrapes of a run: each one starts at a random
+		// offset of the jitter window from the start of the run
+		runJobs := c.jobs()
+		offsets := make([]time.Duration, len(runJobs))
+		if c.jitter > 0 {
+			for i := range offsets {
+				offsets[i] = time.Duration(rand.Int63n(int64(c.jitter)))
+			}
+			sort.Slice(offsets, func(i, k int) bool { return offsets[i] < offsets[k] })
+		}
+		for i, j := range runJobs {
+			select {
+			case <-ctx.Done():
+				return
+			case <-time.After(time.Until(next.Add(offsets[i]))):
+			}
+			select {
+			case <-ctx.Done():
+				return
+			case jobs <- j:
+			}
+		}
+	}
+}
+
+// runJob scrapes one job of a campaign with the scraper of the session,
+// stopped with ctx after its in-flight request
+func runJob(ctx context.Context, sess *session, j job) error {
+	_, err := sess.runScrape(ctx, j.keywords, func(opts *scraper.Options) {
+		opts.Device = j.device
+		opts.Location = j.location
+	})
+	return err
+}
+
+// daemon schedules the scrapes of campaigns until SIGTERM or SIGINT. On
+// shutdown, the in-flight request is finished and the metrics are flushed.
+// It returns the exit code of the process
+func daemon(args []string) int {
+	fs := flag.NewFlagSet("daemon", flag.ExitOnError)
+	campaignPath := fs.String("campaign", "", "json file defining the campaigns")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	if *campaignPath == "" {
+		fmt.Fprintln(os.Stderr, "usage: scrap daemon [flags] --campaign campaign.json")
+		return exitUsage
+	}
+	campaigns, err := loadCampaigns(*campaignPath, cfg)
+	if err != nil {
+		log.Printf("can't load campaigns: %v", err)
+		return exitUsage
+	}
+	if _, err := scraper.SelectParsers(cfg.Parser, ""); err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	if cfg.Output == "json" {
+		// the json array is only written on exit, the daemon would keep every result
+		log.Printf("invalid config: output json isn't streamed, use jsonl in daemon mode")
+		return exitUsage
+	}
+	out, err := newOutput(cfg.Output)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	sinks, err := openSinks(cfg)
+	if err != nil {
+		log.Printf("can't open metrics sinks: %v", err)
+		return exitCode(err)
+	}
+	db, err := openHistory(cfg)
+	if err != nil {
+		log.Printf("can't open history: %v", err)
+		return exitFailure
+	}
+	rules, err := openRules(cfg, db)
+	if err != nil {
+		log.Printf("can't load rules: %v", err)
+		return exitUsage
+	}
+	// one scraper for every job, sharing the budget, proxies and sinks
+	sess, err := newSession(cfg, sinks, out, true)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	sess.store = db
+	sess.rules = rules
+
+	// graceful shutdown
+	ctx, cancel := context.WithCancel(context.Background())
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	go func() {
+		sig := <-signals
+		log.Printf("%v received, finishing in-flight request", sig)
+		cancel()
+	}()
+
+	// one scheduler per campaign, one worker scraping the jobs in turn
+	jobs := make(chan job)
+	var schedulers sync.WaitGroup
+	for _, c := range campaigns {
+		schedulers.Add(1)
+		go func(c *campaign) {
+			defer schedulers.Done()
+			c.schedule(ctx, jobs)
+		}(c)
+	}
+	done := make(chan bool)
+	go func() {
+		for j := range jobs {
+			if err := runJob(ctx, sess, j); err != nil {
+				log.Printf("campaign %s: scrap of %q (%s %s) failed: %v", j.campaign, j.keywords, j.device, j.location, err)
+			}
+		}
+		close(done)
+	}()
+
+	schedulers.Wait()
+	close(jobs)
+	<-done
+	sess.close()
+
+	if err := closeSinks(sinks); err != nil {
+		log.Printf("metrics: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Printf("can't write results: %v", err)
+	}
+	if db != nil {
+		db.Close()
+	}
+	logProxies(sess.proxies)
+	log.Println("daemon stopped")
+	return 0
+}
@@ -0,0 +1,210 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"flag"
+	"fmt"
+	"io"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+	"github.com/garnaud/hackathon-2018/serpdiff"
+)
+
+// position formats a position of the diff, "-" when absent
+func position(p int) string {
+	if p < 0 {
+		return "-"
+	}
+	return fmt.Sprintf("%d", p)
+}
+
+// printDiff prints a diff to stdout
+func printDiff(d *serpdiff.Diff) {
+	fmt.Printf("diff of %q (%s) from %s to %s:\n", d.Keywords, d.Device, d.From.Format(time.RFC3339), d.To.Format(time.RFC3339))
+	if len(d.SEAEntered) > 0 {
+		fmt.Printf("sea entered: %s\n", strings.Join(d.SEAEntered, ", "))
+	}
+	if len(d.SEALeft) > 0 {
+		fmt.Printf("sea left: %s\n", strings.Join(d.SEALeft, ", "))
+	}
+	if len(d.SEOMoves) > 0 {
+		fmt.Println("seo moves:")
+		for _, m := range d.SEOMoves {
+			fmt.Printf("%s -> %s - %s\n", position(m.From), position(m.To), m.Domain)
+		}
+	}
+	if len(d.NewCompetitors) > 0 {
+		fmt.Printf("new competitors: %s\n", strings.Join(d.NewCompetitors, ", "))
+	}
+	for _, w := range d.Waste {
+		fmt.Printf("waste of %s: %d -> %d\n", w.Domain, w.From, w.To)
+	}
+	for _, a := range d.Alerts {
+		fmt.Printf("ALERT: %s\n", a.Message)
+	}
+}
+
+// lastResult returns the last result of a file selected by match, blocked and
+// unparsed results are skipped
+func lastResult(path string, match func(*scraper.Result) bool) (*scraper.Result, error) {
+	results, err := loadResults(path)
+	if err != nil {
+		return nil, err
+	}
+	for i := len(results) - 1; i >= 0; i-- {
+		if results[i].Parsed() && match(results[i]) {
+			return results[i], nil
+		}
+	}
+	return nil, errors.New("no matching result in " + path)
+}
+
+// search describes the device and location of a result
+func search(r *scraper.Result) string {
+	if r.Location == "" {
+		return r.Device
+	}
+	return r.Device + " in " + r.Location
+}
+
+// writeAlerts appends the alerts as json lines to path, "-" for stderr
+func writeAlerts(path string, alerts []serpdiff.Alert) error {
+	if path == "" || len(alerts) == 0 {
+		return nil
+	}
+	writer := io.Writer(os.Stderr)
+	if path != "-" {
+		file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+		if err != nil {
+			return err
+		}
+		defer file.Close()
+		writer = file
+	}
+	encoder := json.NewEncoder(writer)
+	for _, a := range alerts {
+		if err := encoder.Encode(a); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// diff compares two results of the same keywords: the last results of two
+// files, or two results of the history (-db). It returns the exit code of the
+// process
+func diff(args []string) int {
+	fs := flag.NewFlagSet("diff", flag.ExitOnError)
+	keywords := fs.String("keywords", "", "keywords of the compared results (required with -db)")
+	since := fs.String("since", "", "with -db, compare the latest result to the first one from this date (default: the previous result)")
+	alerts := fs.String("alerts", "", "file where alert events are appended as json lines, '-' for stderr")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+
+	var before, after *scraper.Result
+	switch {
+	case cfg.DB != "" && *keywords != "":
+		f := historyFilter{Keywords: *keywords}
+		fs.Visit(func(fl *flag.Flag) {
+			if fl.Name == "device" {
+				f.Device = cfg.Device
+			}
+		})
+		db, err := openStore(cfg.DB)
+		if err != nil {
+			log.Printf("can't open history: %v", err)
+			return exitFailure
+		}
+		results, err := db.Query(f)
+		db.Close()
+		if err != nil {
+			log.Printf("can't read history: %v", err)
+			return exitFailure
+		}
+		// blocked and unparsed results have nothing to compare
+		scraped := make([]*scraper.Result, 0, len(results))
+		for _, r := range results {
+			if r.Parsed() {
+				scraped = append(scraped, r)
+			}
+		}
+		results = scraped
+		from, err := parseDate(*since)
+		if err != nil {
+			log.Printf("-since: %v", err)
+			return exitUsage
+		}
+		// compare results of the same device and location as the latest one
+		if len(results) > 0 {
+			after = results[len(results)-1]
+		}
+		for i := len(results) - 2; i >= 0; i-- {
+			r := results[i]
+			if !serpdiff.SameSearch(r, after) {
+				continue
+			}
+			if from.IsZero() {
+				before = r
+				break
+			}
+			if r.Time.Before(from) {
+				break
+			}
+			before = r
+		}
+		if before == nil {
+			log.Printf("less than two results of %s in %s", *keywords, cfg.DB)
+			return exitFailure
+		}
+	case cfg.DB == "" && fs.NArg() == 2:
+		// the old result is the last one of the same search as the new one
+		after, err = lastResult(fs.Arg(1), func(r *scraper.Result) bool {
+			return *keywords == "" || r.Keywords == *keywords
+		})
+		if err != nil {
+			log.Println(err)
+			return exitFailure
+		}
+		before, err = lastResult(fs.Arg(0), func(r *scraper.Result) bool {
+			return serpdiff.SameSearch(r, after)
+		})
+		if err != nil {
+			log.Printf("%v: can't compare %q (%s)", err, after.Keywords, search(after))
+			return exitFailure
+		}
+	default:
+		fmt.Fprintln(os.Stderr, "usage: scrap diff [flags] old.json new.json")
+		fmt.Fprintln(os.Stderr, "       scrap diff [flags] --db file --keywords k [--since date]")
+		return exitUsage
+	}
+
+	d := serpdiff.Compare(before, after, cfg.Watch)
+	switch cfg.Output {
+	case "json":
+		b, err := json.MarshalIndent(d, "", "  ")
+		if err != nil {
+			log.Printf("can't write diff: %v", err)
+			return exitFailure
+		}
+		fmt.Println(string(b))
+	case "jsonl":
+		if err := json.NewEncoder(os.Stdout).Encode(d); err != nil {
+			log.Printf("can't write diff: %v", err)
+			return exitFailure
+		}
+	default:
+		printDiff(d)
+	}
+	if err := writeAlerts(*alerts, d.Alerts); err != nil {
+		log.Printf("can't write alerts: %v", err)
+	}
+	return 0
+}
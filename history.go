@@ -0,0 +1,97 @@
+package main
+
+import (
+	"errors"
+	"flag"
+	"fmt"
+	"log"
+	"os"
+	"time"
+)
+
+// parseDate parses a date of the history flags: RFC3339 or a day (2006-01-02)
+func parseDate(value string) (time.Time, error) {
+	if value == "" {
+		return time.Time{}, nil
+	}
+	if t, err := time.Parse(time.RFC3339, value); err == nil {
+		return t, nil
+	}
+	t, err := time.ParseInLocation("2006-01-02", value, time.Local)
+	if err != nil {
+		return time.Time{}, errors.New("invalid date " + value + " (use 2006-01-02 or RFC3339)")
+	}
+	return t, nil
+}
+
+// history prints the results kept in the database (-db) matching the filters
+// and returns the exit code of the process
+func history(args []string) int {
+	fs := flag.NewFlagSet("history", flag.ExitOnError)
+	f := historyFilter{}
+	fs.StringVar(&f.Keywords, "keywords", "", "only the results of these keywords")
+	fs.StringVar(&f.Domain, "domain", "", "only the results where this domain appears (or is absent with -absent)")
+	fs.StringVar(&f.In, "in", "any", "where -domain is looked for: sea, seo or any")
+	fs.BoolVar(&f.Absent, "absent", false, "select the results where -domain is absent")
+	since := fs.String("since", "", "only the results from this date (2006-01-02 or RFC3339)")
+	until := fs.String("until", "", "only the results before this date (2006-01-02 or RFC3339)")
+	fs.IntVar(&f.Limit, "limit", 0, "maximum number of results, 0 for all")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	if cfg.DB == "" {
+		fmt.Fprintln(os.Stderr, "usage: scrap history --db file [--keywords k] [--device d] [--domain d --in sea|seo|any [--absent]] [--since date] [--until date] [--limit n]")
+		return exitUsage
+	}
+	if f.In != "sea" && f.In != "seo" && f.In != "any" {
+		log.Printf("unknown -in %s (available: sea, seo, any)", f.In)
+		return exitUsage
+	}
+	if f.Absent && f.Domain == "" {
+		log.Println("-absent needs -domain")
+		return exitUsage
+	}
+	if f.Since, err = parseDate(*since); err != nil {
+		log.Printf("-since: %v", err)
+		return exitUsage
+	}
+	if f.Until, err = parseDate(*until); err != nil {
+		log.Printf("-until: %v", err)
+		return exitUsage
+	}
+	// -device filters only when it is given explicitly, cfg.Device is never empty
+	fs.Visit(func(fl *flag.Flag) {
+		if fl.Name == "device" {
+			f.Device = cfg.Device
+		}
+	})
+
+	db, err := openStore(cfg.DB)
+	if err != nil {
+		log.Printf("can't open history: %v", err)
+		return exitFailure
+	}
+	defer db.Close()
+	out, err := newOutput(cfg.Output)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	results, err := db.Query(f)
+	if err != nil {
+		log.Printf("can't read history: %v", err)
+		return exitFailure
+	}
+	for _, result := range results {
+		if err := out.Write(result); err != nil {
+			log.Printf("can't write result: %v", err)
+		}
+	}
+	if err := out.Close(); err != nil {
+		log.Printf("can't write results: %v", err)
+	}
+	log.Printf("%d results found", len(results))
+	return 0
+}
@@ -0,0 +1,96 @@
+package main
+
+import (
+	"encoding/json"
+	"errors"
+	"fmt"
+	"io"
+	"os"
+	"strings"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// outputFormats are the formats accepted by -output
+var outputFormats = []string{"text", "json", "jsonl"}
+
+// output writes the results in the format given by -output:
+//   - text: Result.Print() of each result
+//   - json: one json array of all the results, written on Close
+//   - jsonl: one json object per line, written as soon as a result is done
+type output struct {
+	format  string
+	writer  io.Writer
+	results []*scraper.Result
+}
+
+// newOutput creates an output to stdout
+func newOutput(format string) (*output, error) {
+	for _, f := range outputFormats {
+		if f == format {
+			return &output{format: format, writer: os.Stdout, results: make([]*scraper.Result, 0)}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown output format %q (available: %v)", format, outputFormats)
+}
+
+// Write a result
+func (o *output) Write(result *scraper.Result) error {
+	switch o.format {
+	case "json":
+		o.results = append(o.results, result)
+		return nil
+	case "jsonl":
+		return json.NewEncoder(o.writer).Encode(result)
+	default:
+		result.Print()
+		return nil
+	}
+}
+
+// Close writes the results kept until the end
+func (o *output) Close() error {
+	if o.format != "json" {
+		return nil
+	}
+	b, err := json.MarshalIndent(o.results, "", "  ")
+	if err != nil {
+		return err
+	}
+	_, err = fmt.Fprintln(o.writer, string(b))
+	return err
+}
+
+// loadResults reads results written by -output json (an array), jsonl or a
+// single result
+func loadResults(path string) ([]*scraper.Result, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	results := make([]*scraper.Result, 0)
+	decoder := json.NewDecoder(file)
+	for {
+		var raw json.RawMessage
+		if err := decoder.Decode(&raw); err == io.EOF {
+			break
+		} else if err != nil {
+			return nil, errors.New("can't read results of " + path + ": " + err.Error())
+		}
+		if strings.HasPrefix(strings.TrimSpace(string(raw)), "[") {
+			list := make([]*scraper.Result, 0)
+			if err := json.Unmarshal(raw, &list); err != nil {
+				return nil, errors.New("can't read results of " + path + ": " + err.Error())
+			}
+			results = append(results, list...)
+			continue
+		}
+		result := &scraper.Result{}
+		if err := json.Unmarshal(raw, result); err != nil {
+			return nil, errors.New("can't read results of " + path + ": " + err.Error())
+		}
+		results = append(results, result)
+	}
+	return results, nil
+}
@@ -0,0 +1,73 @@
+package main
+
+import (
+	"bufio"
+	"log"
+	"os"
+	"strings"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// openProxies returns the proxy pool of the config, opened once by session
+// and shared by its scrapes so that the health of the proxies is kept between
+// scrapes. The proxies come from the -proxies file, else from the PROXIES
+// environment variable; nil when there is none
+func openProxies(cfg *Config) (*scraper.ProxyPool, error) {
+	source := cfg.Proxies
+	var proxies []string
+	var err error
+	if source != "" {
+		proxies, err = readProxies(source)
+		if err != nil {
+			return nil, err
+		}
+	} else if env := os.Getenv("PROXIES"); env != "" {
+		source = "$PROXIES"
+		proxies = strings.FieldsFunc(env, func(r rune) bool {
+			return r == ',' || r == ' ' || r == '\n'
+		})
+	} else {
+		return nil, nil
+	}
+
+	pool, err := scraper.NewProxyPool(proxies, cfg.ProxyStrategy, time.Duration(cfg.ProxyQuarantine))
+	if err != nil {
+		return nil, err
+	}
+	log.Printf("%d proxies from %s (%s)", len(proxies), source, cfg.ProxyStrategy)
+	return pool, nil
+}
+
+// readProxies reads a proxies file: one proxy per line, blank lines and lines
+// starting with '#' are ignored
+func readProxies(path string) ([]string, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+
+	proxies := make([]string, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		line := strings.TrimSpace(scanner.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		proxies = append(proxies, line)
+	}
+	return proxies, scanner.Err()
+}
+
+// logProxies reports the requests and the block rate of each proxy of a pool
+func logProxies(pool *scraper.ProxyPool) {
+	for _, s := range pool.Stats() {
+		status := ""
+		if s.Quarantined {
+			status = ", quarantined"
+		}
+		log.Printf("proxy %s: %d requests, %d blocked (%.0f%%), %d failed, health %.2f%s", s.Proxy, s.Requests, s.Blocks, 100*s.BlockRate, s.Failures, s.Health, status)
+	}
+}
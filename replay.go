@@ -0,0 +1,234 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"fmt"
+	"io/ioutil"
+	"log"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// snapshot is the json sidecar saved next to each fetched page
+type snapshot struct {
+	Keywords  string    `json:"keywords"`       // keywords used for requesting google
+	URL       string    `json:"url"`            // url used for requesting to google
+	UserAgent string    `json:"userAgent"`      // user agent used for requesting to google
+	Device    string    `json:"device"`         // device from user agent ('mobile' or 'desktop')
+	Location  string    `json:"location"`       // canonical name of the city of the search
+	Time      time.Time `json:"time"`           // time of the request to google
+	Prefix    string    `json:"prefix"`         // prefix of the metrics sent for the page
+	Page      int       `json:"page,omitempty"` // page of the keywords, from 0
+}
+
+// saveSnapshot writes the page body and its json sidecar in dir. Files are
+// named by time, device and keywords: 20180621-153000-desktop-paris_lyon.{html,json},
+// next pages get their number: 20180621-153000-desktop-paris_lyon-p2.{html,json}
+func saveSnapshot(dir string, body []byte, result *scraper.Result, prefix string, page int) error {
+	if err := os.MkdirAll(dir, 0755); err != nil {
+		return err
+	}
+	name := filepath.Join(dir, fmt.Sprintf("%s-%s-%s", result.Time.Format("20060102-150405"), result.Device, scraper.MetricName(result.Keywords)))
+	if page > 0 {
+		name = fmt.Sprintf("%s-p%d", name, page+1)
+	}
+	sidecar, err := json.MarshalIndent(snapshot{
+		Keywords:  result.Keywords,
+		URL:       result.URL,
+		UserAgent: result.UserAgent,
+		Device:    result.Device,
+		Location:  result.Location,
+		Time:      result.Time,
+		Prefix:    prefix,
+		Page:      page,
+	}, "", "  ")
+	if err != nil {
+		return err
+	}
+	if err := ioutil.WriteFile(name+".html", body, 0644); err != nil {
+		return err
+	}
+	return ioutil.WriteFile(name+".json", sidecar, 0644)
+}
+
+// loadSnapshot reads a saved page and its sidecar
+func loadSnapshot(htmlFile string) (*snapshot, []byte, error) {
+	body, err := ioutil.ReadFile(htmlFile)
+	if err != nil {
+		return nil, nil, err
+	}
+	sidecar, err := ioutil.ReadFile(strings.TrimSuffix(htmlFile, ".html") + ".json")
+	if err != nil {
+		return nil, nil, err
+	}
+	snap := &snapshot{}
+	if err := json.Unmarshal(sidecar, snap); err != nil {
+		return nil, nil, fmt.Errorf("can't read sidecar of %s: %v", htmlFile, err)
+	}
+	return snap, body, nil
+}
+
+// snapshotFiles lists the saved pages of paths (html files or directories),
+// sorted by name hence by time
+func snapshotFiles(paths []string) ([]string, error) {
+	files := make([]string, 0)
+	for _, path := range paths {
+		info, err := os.Stat(path)
+		if err != nil {
+			return nil, err
+		}
+		if !info.IsDir() {
+			files = append(files, path)
+			continue
+		}
+		matches, err := filepath.Glob(filepath.Join(path, "*.html"))
+		if err != nil {
+			return nil, err
+		}
+		sort.Strings(matches)
+		files = append(files, matches...)
+	}
+	return files, nil
+}
+
+// replay feeds saved pages through the SEA/SEO extraction and the waste
+// computation, and sends the metrics at the time of the original request. It
+// returns the exit code of the process
+func replay(args []string) int {
+	fs := flag.NewFlagSet("replay", flag.ExitOnError)
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	if fs.NArg() < 1 {
+		fmt.Fprintln(os.Stderr, "usage: scrap replay [--config file] [--watch domain[=siblings]] [--parser name] file.html|dir...")
+		return exitUsage
+	}
+	if _, err := scraper.SelectParsers(cfg.Parser, ""); err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	out, err := newOutput(cfg.Output)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	sinks, err := openSinks(cfg)
+	if err != nil {
+		log.Printf("can't open metrics sinks: %v", err)
+		return exitCode(err)
+	}
+	db, err := openHistory(cfg)
+	if err != nil {
+		log.Printf("can't open history: %v", err)
+		return exitFailure
+	}
+	rules, err := openRules(cfg, db)
+	if err != nil {
+		log.Printf("can't load rules: %v", err)
+		return exitUsage
+	}
+	files, err := snapshotFiles(fs.Args())
+	if err != nil {
+		log.Printf("can't read saved pages: %v", err)
+		return exitUsage
+	}
+
+	// load the pages, the next pages of keywords are replayed with the first one
+	type page struct {
+		file string
+		snap *snapshot
+		body []byte
+	}
+	failed := make(map[string]error)
+	pages := make([]page, 0, len(files))
+	for _, file := range files {
+		snap, body, err := loadSnapshot(file)
+		if err != nil {
+			log.Printf("replay of %s failed: %v", file, err)
+			failed[file] = err
+			continue
+		}
+		pages = append(pages, page{file: file, snap: snap, body: body})
+	}
+	sameRequest := func(a, b *snapshot) bool {
+		return a.Time.Equal(b.Time) && a.Device == b.Device && a.Keywords == b.Keywords
+	}
+	sort.SliceStable(pages, func(i, j int) bool {
+		a, b := pages[i].snap, pages[j].snap
+		if !sameRequest(a, b) {
+			return pages[i].file < pages[j].file
+		}
+		return a.Page < b.Page
+	})
+
+	for i := 0; i < len(pages); {
+		first := pages[i]
+		group := []page{first}
+		for i++; i < len(pages) && pages[i].snap.Page > 0 && sameRequest(first.snap, pages[i].snap); i++ {
+			group = append(group, pages[i])
+		}
+
+		snap := first.snap
+		result := scraper.NewResult(snap.Keywords, cfg.Watch)
+		result.URL = snap.URL
+		result.UserAgent = snap.UserAgent
+		result.Device = snap.Device
+		result.Location = snap.Location
+		result.Time = snap.Time
+		for _, p := range group {
+			if err := scraper.AnalysePage(result, p.body, cfg.Parser, p.snap.Page); err != nil {
+				log.Printf("replay of %s failed: %v", p.file, err)
+				failed[p.file] = err
+				break
+			}
+		}
+		if _, ok := failed[first.file]; ok {
+			continue
+		}
+		result.Score(cfg.Watch)
+		if err := out.Write(result); err != nil {
+			log.Printf("can't write result of %s: %v", first.file, err)
+		}
+
+		if db != nil {
+			if err := db.Save(result); err != nil {
+				log.Printf("can't save result of %s in history: %v", first.file, err)
+			}
+		}
+		rules.Check(result)
+
+		met := scraper.NewMetrics(snap.Prefix, sinks)
+		scraper.Publish(&met, result, cfg.Watch)
+		met.Close()
+	}
+
+	if err := closeSinks(sinks); err != nil {
+		log.Printf("metrics: %v", err)
+	}
+	if err := out.Close(); err != nil {
+		log.Printf("can't write results: %v", err)
+	}
+	if db != nil {
+		db.Close()
+	}
+
+	// summary
+	log.Printf("summary: %d pages replayed, %d failed", len(files), len(failed))
+	for _, file := range files {
+		if err, ok := failed[file]; ok {
+			log.Printf("failed: %s (%v)", file, err)
+		}
+	}
+	if len(failed) > 0 {
+		return exitFailure
+	}
+	return 0
+}
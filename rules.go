@@ -0,0 +1,408 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"log"
+	"net/http"
+	"net/smtp"
+	"os"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+	"github.com/garnaud/hackathon-2018/serpdiff"
+)
+
+// rule raises a notification when its condition holds for a number of
+// consecutive results of the same keywords and device. Conditions are either
+// a threshold on a metric ("metric", "op", "value") or a competitor above a
+// domain ("competitor", "in")
+type rule struct {
+	Name        string   `json:"name"`
+	Keywords    string   `json:"keywords"`    // keywords checked, "" for all
+	Device      string   `json:"device"`      // device checked, "" for all
+	Domain      string   `json:"domain"`      // first watched domain by default
+	Metric      string   `json:"metric"`      // waste, seo.density, seo.count, seo.first, sea.first of the domain, or sea.total, seo.total
+	Op          string   `json:"op"`          // ==, !=, >, >=, <, <=
+	Value       float64  `json:"value"`       // threshold of the metric
+	Competitor  string   `json:"competitor"`  // raises when the competitor is above the domain
+	In          string   `json:"in"`          // where the competitor is compared: sea (default) or seo
+	Consecutive int      `json:"consecutive"` // number of consecutive results matching the condition, 1 by default
+	Notify      []string `json:"notify"`      // names of the notifiers, all of them by default
+}
+
+// ruleMetrics are the metrics accepted by the threshold rules
+var ruleMetrics = []string{"waste", "seo.density", "seo.count", "seo.first", "sea.first", "sea.total", "seo.total"}
+
+// ruleOps compare a metric to the threshold of a rule
+var ruleOps = map[string]func(a, b float64) bool{
+	"==": func(a, b float64) bool { return a == b },
+	"!=": func(a, b float64) bool { return a != b },
+	">":  func(a, b float64) bool { return a > b },
+	">=": func(a, b float64) bool { return a >= b },
+	"<":  func(a, b float64) bool { return a < b },
+	"<=": func(a, b float64) bool { return a <= b },
+}
+
+// notifierConfig defines a notifier: webhook (url), smtp (address, from, to,
+// username, password) or file (path)
+type notifierConfig struct {
+	Name     string   `json:"name"`
+	Type     string   `json:"type"`
+	URL      string   `json:"url,omitempty"`
+	Address  string   `json:"address,omitempty"`
+	From     string   `json:"from,omitempty"`
+	To       []string `json:"to,omitempty"`
+	Username string   `json:"username,omitempty"`
+	Password string   `json:"password,omitempty"`
+	Path     string   `json:"path,omitempty"`
+}
+
+// rulesFile is the json definition of the rules and their notifiers
+type rulesFile struct {
+	Rules     []*rule          `json:"rules"`
+	Notifiers []notifierConfig `json:"notifiers"`
+}
+
+// notification sent when a rule is raised
+type notification struct {
+	Rule     string    `json:"rule"`
+	Keywords string    `json:"keywords"`
+	Device   string    `json:"device"`
+	Location string    `json:"location,omitempty"`
+	Domain   string    `json:"domain"`
+	Time     time.Time `json:"time"` // time of the last result
+	Value    float64   `json:"value"`
+	Runs     int       `json:"runs"` // consecutive results matching the rule
+	Message  string    `json:"message"`
+}
+
+// Notifier sends the notifications of the rules
+type Notifier interface {
+	Notify(n notification) error
+}
+
+// ruleEngine checks the rules on each result. The consecutive matches of a
+// rule are counted by keywords, device and location, and seeded from the
+// history when there is one
+type ruleEngine struct {
+	rules     []*rule
+	notifiers map[string]Notifier
+	watch     []scraper.WatchedDomain
+	history   *store
+
+	mutex   sync.Mutex
+	streaks map[string]int
+}
+
+// loadRules reads the rules file and creates its notifiers
+func loadRules(path string, cfg *Config, history *store) (*ruleEngine, error) {
+	file, err := os.Open(path)
+	if err != nil {
+		return nil, err
+	}
+	defer file.Close()
+	definition := rulesFile{}
+	if err := json.NewDecoder(file).Decode(&definition); err != nil {
+		return nil, errors.New("can't read rules file " + path + ": " + err.Error())
+	}
+
+	e := &ruleEngine{
+		rules:     definition.Rules,
+		notifiers: make(map[string]Notifier),
+		watch:     cfg.Watch,
+		history:   history,
+		streaks:   make(map[string]int),
+	}
+	for i, nc := range definition.Notifiers {
+		if nc.Name == "" {
+			nc.Name = fmt.Sprintf("%s-%d", nc.Type, i)
+		}
+		if _, ok := e.notifiers[nc.Name]; ok {
+			return nil, errors.New("notifier " + nc.Name + " is defined twice")
+		}
+		var n Notifier
+		switch nc.Type {
+		case "webhook":
+			if nc.URL == "" {
+				return nil, errors.New("webhook notifier " + nc.Name + " has no url")
+			}
+			n = &webhookNotifier{url: nc.URL, client: &http.Client{Timeout: 10 * time.Second}}
+		case "smtp":
+			if nc.Address == "" || nc.From == "" || len(nc.To) == 0 {
+				return nil, errors.New("smtp notifier " + nc.Name + " needs address, from and to")
+			}
+			n = &smtpNotifier{address: nc.Address, from: nc.From, to: nc.To, username: nc.Username, password: nc.Password}
+		case "file":
+			if nc.Path == "" {
+				nc.Path = "alerts.jsonl"
+			}
+			n = &fileNotifier{path: nc.Path}
+		default:
+			return nil, fmt.Errorf("unknown notifier type %q (available: webhook, smtp, file)", nc.Type)
+		}
+		e.notifiers[nc.Name] = n
+	}
+
+	for i, r := range e.rules {
+		if r.Name == "" {
+			r.Name = fmt.Sprintf("rule-%d", i)
+		}
+		if r.Domain == "" && len(cfg.Watch) > 0 {
+			r.Domain = cfg.Watch[0].Domain
+		}
+		if r.Consecutive <= 0 {
+			r.Consecutive = 1
+		}
+		if r.Competitor != "" {
+			if r.In == "" {
+				r.In = "sea"
+			}
+			if r.In != "sea" && r.In != "seo" {
+				return nil, errors.New("rule " + r.Name + ": unknown in " + r.In + " (available: sea, seo)")
+			}
+		} else {
+			if !contains(ruleMetrics, r.Metric) {
+				return nil, fmt.Errorf("rule %s: unknown metric %q (available: %s)", r.Name, r.Metric, strings.Join(ruleMetrics, ", "))
+			}
+			if _, ok := ruleOps[r.Op]; !ok {
+				return nil, fmt.Errorf("rule %s: unknown op %q", r.Name, r.Op)
+			}
+		}
+		for _, name := range r.Notify {
+			if _, ok := e.notifiers[name]; !ok {
+				return nil, errors.New("rule " + r.Name + ": unknown notifier " + name)
+			}
+		}
+	}
+	return e, nil
+}
+
+// openRules loads the rules of the config, nil when there are none
+func openRules(cfg *Config, history *store) (*ruleEngine, error) {
+	if cfg.Rules == "" {
+		return nil, nil
+	}
+	return loadRules(cfg.Rules, cfg, history)
+}
+
+// contains returns true if s is in list
+func contains(list []string, s string) bool {
+	for _, item := range list {
+		if item == s {
+			return true
+		}
+	}
+	return false
+}
+
+// watched returns the watched domain of the config, with its siblings
+func (e *ruleEngine) watched(domain string) scraper.WatchedDomain {
+	for _, w := range e.watch {
+		if w.Domain == domain {
+			return w
+		}
+	}
+	return scraper.WatchedDomain{Domain: domain}
+}
+
+// applies returns true if the rule checks the result
+func (r *rule) applies(result *scraper.Result) bool {
+	return (r.Keywords == "" || r.Keywords == result.Keywords) && (r.Device == "" || r.Device == result.Device)
+}
+
+// eval returns true when the condition of the rule holds for the result,
+// with the value compared and a description
+func (e *ruleEngine) eval(r *rule, result *scraper.Result) (bool, float64, string) {
+	scored := serpdiff.Rescore(result, []scraper.WatchedDomain{e.watched(r.Domain)})
+	if r.Competitor != "" {
+		results := scored.FirstPageSEA()
+		if r.In == "seo" {
+			results = scored.SEO
+		}
+		competitor, ours := -1, -1
+		for _, sr := range results {
+			if competitor < 0 && sr.Domain == r.Competitor {
+				competitor = sr.Position
+			}
+			if ours < 0 && sr.Domain == r.Domain {
+				ours = sr.Position
+			}
+		}
+		above := competitor >= 0 && (ours < 0 || competitor < ours)
+		return above, float64(competitor), fmt.Sprintf("%s is above %s in %s", r.Competitor, r.Domain, r.In)
+	}
+
+	var value float64
+	switch r.Metric {
+	case "waste":
+		value = float64(scored.Waste[r.Domain])
+	case "seo.density":
+		value = scored.SEODensity[r.Domain]
+	case "seo.count":
+		value = float64(scored.SEOCount[r.Domain])
+	case "seo.first":
+		value = float64(scored.SEOFirst[r.Domain])
+	case "sea.first":
+		value = float64(scored.SEAFirst[r.Domain])
+	case "sea.total":
+		value = float64(len(scored.FirstPageSEA()))
+	case "seo.total":
+		value = float64(len(scored.SEO))
+	}
+	return ruleOps[r.Op](value, r.Value), value, fmt.Sprintf("%s of %s is %v (%s %v)", r.Metric, r.Domain, value, r.Op, r.Value)
+}
+
+// streakKey identifies the results counted together by a rule
+func streakKey(r *rule, result *scraper.Result) string {
+	return r.Name + "|" + result.Keywords + "|" + result.Device + "|" + result.Location
+}
+
+// seed counts the consecutive results of the history matching the rule
+// before the result
+func (e *ruleEngine) seed(r *rule, result *scraper.Result) int {
+	if e.history == nil || r.Consecutive <= 1 {
+		return 0
+	}
+	previous, err := e.history.Query(historyFilter{Keywords: result.Keywords, Device: result.Device, Until: result.Time})
+	if err != nil {
+		log.Printf("rule %s: can't read history: %v", r.Name, err)
+		return 0
+	}
+	streak := 0
+	for i := len(previous) - 1; i >= 0 && streak < r.Consecutive-1; i-- {
+		if previous[i].Location != result.Location || !previous[i].Parsed() {
+			continue
+		}
+		if ok, _, _ := e.eval(r, previous[i]); !ok {
+			break
+		}
+		streak++
+	}
+	return streak
+}
+
+// Check evaluates the rules on a result and sends the notifications of the
+// rules raised. A rule is raised once when its streak reaches its consecutive
+// count, and again after its condition stopped holding
+func (e *ruleEngine) Check(result *scraper.Result) {
+	if e == nil {
+		return
+	}
+	e.mutex.Lock()
+	defer e.mutex.Unlock()
+	for _, r := range e.rules {
+		if !r.applies(result) {
+			continue
+		}
+		key := streakKey(r, result)
+		streak, ok := e.streaks[key]
+		if !ok {
+			streak = e.seed(r, result)
+		}
+		holds, value, description := e.eval(r, result)
+		if !holds {
+			e.streaks[key] = 0
+			continue
+		}
+		streak++
+		e.streaks[key] = streak
+		if streak != r.Consecutive {
+			continue
+		}
+
+		n := notification{
+			Rule:     r.Name,
+			Keywords: result.Keywords,
+			Device:   result.Device,
+			Location: result.Location,
+			Domain:   r.Domain,
+			Time:     result.Time,
+			Value:    value,
+			Runs:     streak,
+			Message:  fmt.Sprintf("rule %s raised for %q (%s): %s", r.Name, result.Keywords, result.Device, description),
+		}
+		if streak > 1 {
+			n.Message += fmt.Sprintf(" for %d consecutive runs", streak)
+		}
+		log.Println(n.Message)
+		names := r.Notify
+		if len(names) == 0 {
+			for name := range e.notifiers {
+				names = append(names, name)
+			}
+		}
+		for _, name := range names {
+			if err := e.notifiers[name].Notify(n); err != nil {
+				log.Printf("notifier %s: %v", name, err)
+			}
+		}
+	}
+}
+
+// webhookNotifier posts the notifications as json
+type webhookNotifier struct {
+	url    string
+	client *http.Client
+}
+
+func (w *webhookNotifier) Notify(n notification) error {
+	body, err := json.Marshal(n)
+	if err != nil {
+		return err
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	resp.Body.Close()
+	if resp.StatusCode >= 300 {
+		return fmt.Errorf("webhook %s answered %s", w.url, resp.Status)
+	}
+	return nil
+}
+
+// smtpNotifier sends the notifications by email
+type smtpNotifier struct {
+	address  string // host:port
+	from     string
+	to       []string
+	username string // no authentication when empty
+	password string
+}
+
+func (s *smtpNotifier) Notify(n notification) error {
+	var auth smtp.Auth
+	if s.username != "" {
+		host := strings.Split(s.address, ":")[0]
+		auth = smtp.PlainAuth("", s.username, s.password, host)
+	}
+	body, err := json.MarshalIndent(n, "", "  ")
+	if err != nil {
+		return err
+	}
+	msg := "From: " + s.from + "\r\n" +
+		"To: " + strings.Join(s.to, ", ") + "\r\n" +
+		"Subject: [scrap] " + n.Message + "\r\n" +
+		"Content-Type: text/plain; charset=utf-8\r\n" +
+		"\r\n" + n.Message + "\r\n\r\n" + string(body) + "\r\n"
+	return smtp.SendMail(s.address, auth, s.from, s.to, []byte(msg))
+}
+
+// fileNotifier appends the notifications as json lines to a file
+type fileNotifier struct {
+	path string
+}
+
+func (f *fileNotifier) Notify(n notification) error {
+	file, err := os.OpenFile(f.path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	return json.NewEncoder(file).Encode(n)
+}
@@ -0,0 +1,275 @@
+package main
+
+import (
+	"bufio"
+	"encoding/json"
+	"io/ioutil"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+var testNotification = notification{
+	Rule:     "useless bid",
+	Keywords: "paris lyon",
+	Device:   "desktop",
+	Domain:   "www.oui.sncf",
+	Time:     time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC),
+	Value:    1,
+	Runs:     3,
+	Message:  `rule useless bid raised for "paris lyon" (desktop)`,
+}
+
+func TestWebhookNotifier(t *testing.T) {
+	received := make([]notification, 0)
+	status := http.StatusOK
+	server := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, r *http.Request) {
+		if ct := r.Header.Get("Content-Type"); ct != "application/json" {
+			t.Errorf("Content-Type = %q, want application/json", ct)
+		}
+		n := notification{}
+		if err := json.NewDecoder(r.Body).Decode(&n); err != nil {
+			t.Errorf("can't read notification: %v", err)
+		}
+		received = append(received, n)
+		w.WriteHeader(status)
+	}))
+	defer server.Close()
+
+	w := &webhookNotifier{url: server.URL, client: server.Client()}
+	if err := w.Notify(testNotification); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	if !reflect.DeepEqual(received, []notification{testNotification}) {
+		t.Errorf("webhook received %+v, want %+v", received, testNotification)
+	}
+
+	status = http.StatusInternalServerError
+	if err := w.Notify(testNotification); err == nil {
+		t.Error("Notify() to a failing webhook returned no error")
+	}
+}
+
+func TestFileNotifier(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	path := filepath.Join(dir, "alerts.jsonl")
+	f := &fileNotifier{path: path}
+	for i := 0; i < 2; i++ {
+		if err := f.Notify(testNotification); err != nil {
+			t.Fatalf("Notify() = %v", err)
+		}
+	}
+
+	file, err := os.Open(path)
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer file.Close()
+	lines := 0
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		n := notification{}
+		if err := json.Unmarshal(scanner.Bytes(), &n); err != nil {
+			t.Fatalf("line %d: %v", lines, err)
+		}
+		if !reflect.DeepEqual(n, testNotification) {
+			t.Errorf("line %d = %+v, want %+v", lines, n, testNotification)
+		}
+		lines++
+	}
+	if lines != 2 {
+		t.Errorf("%d notifications appended, want 2", lines)
+	}
+}
+
+// fakeSMTP accepts one mail without authentication on listener and sends its
+// envelope and data on the returned channel
+func fakeSMTP(listener net.Listener) <-chan []string {
+	mail := make(chan []string, 1)
+	go func() {
+		conn, err := listener.Accept()
+		if err != nil {
+			return
+		}
+		defer conn.Close()
+		reader := bufio.NewReader(conn)
+		reply := func(line string) { conn.Write([]byte(line + "\r\n")) }
+		reply("220 localhost ESMTP")
+		lines := make([]string, 0)
+		data := false
+		for {
+			line, err := reader.ReadString('\n')
+			if err != nil {
+				return
+			}
+			line = strings.TrimRight(line, "\r\n")
+			switch {
+			case data && line == ".":
+				data = false
+				reply("250 queued")
+			case data:
+				lines = append(lines, line)
+			case strings.HasPrefix(line, "EHLO"), strings.HasPrefix(line, "HELO"):
+				reply("250 localhost")
+			case strings.HasPrefix(line, "MAIL"), strings.HasPrefix(line, "RCPT"):
+				lines = append(lines, line)
+				reply("250 ok")
+			case line == "DATA":
+				data = true
+				reply("354 go ahead")
+			case line == "QUIT":
+				reply("221 bye")
+				mail <- lines
+				return
+			default:
+				reply("502 unknown command")
+			}
+		}
+	}()
+	return mail
+}
+
+func TestSMTPNotifier(t *testing.T) {
+	listener, err := net.Listen("tcp", "127.0.0.1:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer listener.Close()
+	mail := fakeSMTP(listener)
+	s := &smtpNotifier{address: listener.Addr().String(), from: "scrap@example.com", to: []string{"seo@example.com", "sea@example.com"}}
+	if err := s.Notify(testNotification); err != nil {
+		t.Fatalf("Notify() = %v", err)
+	}
+	lines := <-mail
+	text := strings.Join(lines, "\n")
+	for _, want := range []string{
+		"MAIL FROM:<scrap@example.com>",
+		"RCPT TO:<seo@example.com>",
+		"RCPT TO:<sea@example.com>",
+		"To: seo@example.com, sea@example.com",
+		"Subject: [scrap] " + testNotification.Message,
+		`"rule": "useless bid"`,
+	} {
+		if !strings.Contains(text, want) {
+			t.Errorf("mail doesn't contain %q:\n%s", want, text)
+		}
+	}
+}
+
+// recorder is a notifier keeping the notifications
+type recorder struct {
+	notifications []notification
+}
+
+func (r *recorder) Notify(n notification) error {
+	r.notifications = append(r.notifications, n)
+	return nil
+}
+
+// ruleResult builds a parsed desktop result of "paris lyon" with ads or not
+func ruleResult(at time.Time, ads bool) *scraper.Result {
+	r := scraper.NewResult("paris lyon", nil)
+	r.Device = "desktop"
+	r.Parser = "v2019"
+	r.Time = at
+	if ads {
+		r.SEA = append(r.SEA, scraper.SearchResult{Domain: "www.trainline.fr", Block: "top"})
+	}
+	return r
+}
+
+// adsRuleEngine returns an engine raising a rule after 3 consecutive results
+// with ads
+func adsRuleEngine(history *store) (*ruleEngine, *recorder) {
+	rec := &recorder{}
+	return &ruleEngine{
+		rules:     []*rule{{Name: "ads", Domain: "www.oui.sncf", Metric: "sea.total", Op: ">", Value: 0, Consecutive: 3}},
+		notifiers: map[string]Notifier{"recorder": rec},
+		history:   history,
+		streaks:   make(map[string]int),
+	}, rec
+}
+
+func TestCheckStreak(t *testing.T) {
+	e, rec := adsRuleEngine(nil)
+	start := time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC)
+	// raised once at the 3rd result with ads, again after a result without
+	ads := []bool{true, true, true, true, false, true, true, true}
+	raised := make([]int, 0)
+	for i, a := range ads {
+		before := len(rec.notifications)
+		e.Check(ruleResult(start.Add(time.Duration(i)*time.Hour), a))
+		if len(rec.notifications) > before {
+			raised = append(raised, i)
+		}
+	}
+	if want := []int{2, 7}; !reflect.DeepEqual(raised, want) {
+		t.Errorf("rule raised at results %v, want %v", raised, want)
+	}
+	for _, n := range rec.notifications {
+		if n.Runs != 3 || n.Value != 1 {
+			t.Errorf("notification %+v, want 3 runs of value 1", n)
+		}
+	}
+
+	// other devices have their own streak
+	mobile := ruleResult(start.Add(10*time.Hour), true)
+	mobile.Device = "mobile"
+	before := len(rec.notifications)
+	e.Check(mobile)
+	if len(rec.notifications) != before {
+		t.Error("rule raised by the first mobile result")
+	}
+}
+
+func TestCheckSeed(t *testing.T) {
+	dir, err := ioutil.TempDir("", "scrap")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer os.RemoveAll(dir)
+	db, err := openStore(filepath.Join(dir, "history.db"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer db.Close()
+	start := time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC)
+	unparsed := ruleResult(start.Add(time.Hour), false)
+	unparsed.Parser = ""
+	blocked := ruleResult(start.Add(2*time.Hour), false)
+	blocked.Blocked = true
+	// two results with ads, blocked and unparsed results don't break the streak
+	for _, r := range []*scraper.Result{ruleResult(start, true), unparsed, blocked, ruleResult(start.Add(3*time.Hour), true)} {
+		if err := db.Save(r); err != nil {
+			t.Fatal(err)
+		}
+	}
+
+	e, rec := adsRuleEngine(db)
+	e.Check(ruleResult(start.Add(4*time.Hour), true))
+	if len(rec.notifications) != 1 {
+		t.Fatalf("%d notifications after 3 results with ads, want 1", len(rec.notifications))
+	}
+
+	// a result without ads in the history resets the streak
+	e, rec = adsRuleEngine(db)
+	if err := db.Save(ruleResult(start.Add(5*time.Hour), false)); err != nil {
+		t.Fatal(err)
+	}
+	e.Check(ruleResult(start.Add(6*time.Hour), true))
+	if len(rec.notifications) != 0 {
+		t.Errorf("%d notifications after a result without ads, want 0", len(rec.notifications))
+	}
+}
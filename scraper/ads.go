@@ -0,0 +1,161 @@
+package scraper
+
+import (
+	"net/url"
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// AdCreative is the content of an ad, as far as the layout shows it
+type AdCreative struct {
+	Headline    string     `json:"headline,omitempty"`
+	Description string     `json:"description,omitempty"`
+	DisplayURL  string     `json:"displayUrl,omitempty"`  // url shown in the ad, "www.oui.sncf › train"
+	DisplayPath string     `json:"displayPath,omitempty"` // path of the display url, "/train"
+	LandingURL  string     `json:"landingUrl,omitempty"`  // real destination of the click, behind the google redirection
+	Sitelinks   []Sitelink `json:"sitelinks,omitempty"`
+	Callouts    []string   `json:"callouts,omitempty"`
+	Prices      []AdPrice  `json:"prices,omitempty"`
+}
+
+// Sitelink is an additional link of an ad
+type Sitelink struct {
+	Title      string `json:"title"`
+	LandingURL string `json:"landingUrl,omitempty"`
+}
+
+// AdPrice is an item of a price extension, or the price of a shopping unit
+type AdPrice struct {
+	Title string `json:"title,omitempty"`
+	Price string `json:"price"`
+}
+
+// adSelectors locate the parts of an ad. Several layouts are listed for each
+// part, the first found is kept
+var adSelectors = struct {
+	headline, description, link, sitelinks, callouts, prices string
+}{
+	headline:    "h3, .pla-unit-title, .ad_cclk a",
+	description: ".ads-creative, .ellip, .pla-unit-title + div",
+	link:        ".ad_cclk a[href], h3 a[href], a[href] h3, a.pla-unit-title-link[href], a[href]",
+	sitelinks:   ".ads-sitelinks a[href], .sitelinks a[href], ul.OkkX2d a[href], .sld a[href]",
+	callouts:    ".ads-callout, .callout, .ads-callouts span",
+	prices:      ".ads-price, .price, .pla-unit .e10twf, .mrH1y",
+}
+
+// priceRE matches a price in the text of a shopping unit
+var priceRE = regexp.MustCompile(`(?:[€$£]\s?\d[\d\s.,]*|\d[\d\s.,]*\s?(?:€|EUR|\$|£))`)
+
+// googleHost returns true for the hosts of google redirections
+func googleHost(host string) bool {
+	return host == "" || strings.Contains(host, "google") || strings.HasSuffix(host, "doubleclick.net")
+}
+
+// landingURL returns the destination of a link of the page, behind google
+// redirections (/aclk?...&adurl=, /url?q=), "" when it is a google link
+func landingURL(href string) string {
+	URL, err := url.Parse(strings.TrimSpace(href))
+	if err != nil || href == "" {
+		return ""
+	}
+	query := URL.Query()
+	if adurl := query.Get("adurl"); adurl != "" {
+		return adurl
+	}
+	if googleHost(URL.Hostname()) && URL.Path == "/url" {
+		for _, param := range []string{"q", "url"} {
+			if target := query.Get(param); strings.HasPrefix(target, "http") {
+				return target
+			}
+		}
+	}
+	if URL.IsAbs() && !googleHost(URL.Hostname()) {
+		return URL.String()
+	}
+	return ""
+}
+
+// displayPath returns the path of a display url: "www.oui.sncf › train" or
+// "www.oui.sncf/train" gives "/train"
+func displayPath(display string) string {
+	display = strings.TrimSpace(strings.Replace(display, "›", "/", -1))
+	display = strings.TrimPrefix(strings.TrimPrefix(display, "https://"), "http://")
+	i := strings.Index(display, "/")
+	if i < 0 {
+		return ""
+	}
+	path := strings.Join(strings.Fields(display[i:]), "")
+	if path == "/" {
+		return ""
+	}
+	return path
+}
+
+// cleanText returns the text of a selection with collapsed whitespaces
+func cleanText(s *goquery.Selection) string {
+	return strings.Join(strings.Fields(s.Text()), " ")
+}
+
+// extractAd reads the creative of an ad container. display is the display
+// url found by the parser
+func extractAd(ad *goquery.Selection, display string) *AdCreative {
+	creative := &AdCreative{
+		Headline:    cleanText(ad.Find(adSelectors.headline).First()),
+		Description: cleanText(ad.Find(adSelectors.description).First()),
+		DisplayURL:  strings.TrimSpace(display),
+		DisplayPath: displayPath(display),
+	}
+
+	// landing url of the headline, else of the first link leaving google
+	ad.Find(adSelectors.link).EachWithBreak(func(i int, link *goquery.Selection) bool {
+		if !link.Is("a") {
+			link = link.ParentsFiltered("a").First()
+		}
+		href, _ := link.Attr("href")
+		creative.LandingURL = landingURL(href)
+		return creative.LandingURL == ""
+	})
+
+	ad.Find(adSelectors.sitelinks).Each(func(i int, link *goquery.Selection) {
+		title := cleanText(link)
+		if title == "" || title == creative.Headline {
+			return
+		}
+		href, _ := link.Attr("href")
+		creative.Sitelinks = append(creative.Sitelinks, Sitelink{Title: title, LandingURL: landingURL(href)})
+	})
+	ad.Find(adSelectors.callouts).Each(func(i int, callout *goquery.Selection) {
+		for _, text := range strings.Split(cleanText(callout), "·") {
+			if text = strings.TrimSpace(text); text != "" {
+				creative.Callouts = append(creative.Callouts, text)
+			}
+		}
+	})
+	ad.Find(adSelectors.prices).Each(func(i int, price *goquery.Selection) {
+		text := cleanText(price)
+		amount := strings.TrimSpace(priceRE.FindString(text))
+		if amount == "" {
+			return
+		}
+		title := strings.TrimSpace(strings.Replace(text, amount, "", 1))
+		creative.Prices = append(creative.Prices, AdPrice{Title: strings.Trim(title, " -·:"), Price: amount})
+	})
+	if len(creative.Prices) == 0 && ad.Is(".pla-unit") {
+		// shopping units show their price without a known class
+		if amount := strings.TrimSpace(priceRE.FindString(cleanText(ad))); amount != "" {
+			creative.Prices = append(creative.Prices, AdPrice{Title: creative.Headline, Price: amount})
+		}
+	}
+	return creative
+}
+
+// adContainer returns the element holding the whole ad of a label of the 2018
+// layout: its ad list item, else the parent of the label
+func adContainer(label *goquery.Selection) *goquery.Selection {
+	if ad := label.Closest("li.ads-ad, div.ads-ad, li"); ad.Length() > 0 {
+		return ad
+	}
+	return label.Parent()
+}
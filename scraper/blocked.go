@@ -0,0 +1,53 @@
+package scraper
+
+import (
+	"bytes"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// captchaSelector matches the captcha forms of the pages of google asking to
+// prove that the user isn't a robot
+const captchaSelector = "#captcha-form, form[action*='/sorry'], .g-recaptcha, #recaptcha"
+
+// resultsSelector matches the container of the results of a page. A page of
+// results isn't a block whatever its snippets say ("trafic exceptionnel" of a
+// news about trains...)
+const resultsSelector = "#search, #ires"
+
+// unusualTraffic are the messages of the block page in the known languages
+var unusualTraffic = []string{
+	"unusual traffic from your computer network",
+	"trafic exceptionnel",
+	"ungewöhnlichen Datenverkehr",
+	"tráfico inusual",
+	"traffico insolito",
+}
+
+// blockedPage returns why a page of google is a block instead of results:
+// the /sorry interstitial, else a captcha or the unusual traffic message of a
+// page without results container, "" for a page of results
+func blockedPage(URL *url.URL, body []byte) string {
+	if strings.HasPrefix(URL.Path, "/sorry") {
+		return "redirected to " + URL.Path
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return ""
+	}
+	if doc.Find(resultsSelector).Length() > 0 {
+		return ""
+	}
+	if doc.Find(captchaSelector).Length() > 0 {
+		return "captcha in the page"
+	}
+	text := doc.Find("body").Text()
+	for _, message := range unusualTraffic {
+		if strings.Contains(text, message) {
+			return "unusual traffic page"
+		}
+	}
+	return ""
+}
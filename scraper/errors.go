@@ -0,0 +1,48 @@
+package scraper
+
+import "errors"
+
+// Kinds of the failures of a scrape, see KindOf
+var (
+	ErrDeviceMismatch = errors.New("user agent doesn't match the device")
+	ErrBlocked        = errors.New("blocked by google")
+	ErrParse          = errors.New("page can't be parsed")
+	ErrSink           = errors.New("metrics sink failed")
+)
+
+// Error is a failure of a scrape of a known kind
+type Error struct {
+	Kind error // one of the Err variables
+	Err  error // cause of the failure
+}
+
+func (e *Error) Error() string {
+	return e.Kind.Error() + ": " + e.Err.Error()
+}
+
+// Unwrap returns the cause of the failure
+func (e *Error) Unwrap() error {
+	return e.Err
+}
+
+// Is makes errors.Is match the kind of the failure
+func (e *Error) Is(target error) bool {
+	return target == e.Kind
+}
+
+// KindOf returns the kind of a failure: one of the Err variables, nil for
+// other errors (network, config...)
+func KindOf(err error) error {
+	switch e := err.(type) {
+	case *Error:
+		return e.Kind
+	case nil:
+		return nil
+	}
+	for _, kind := range []error{ErrDeviceMismatch, ErrBlocked, ErrParse, ErrSink} {
+		if err == kind {
+			return kind
+		}
+	}
+	return nil
+}
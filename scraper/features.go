@@ -0,0 +1,101 @@
+package scraper
+
+import (
+	"sort"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// Feature is a block of the page which is neither an ad nor an organic
+// result, it pushes the organic results down
+type Feature struct {
+	Name     string `json:"name"`
+	Position int    `json:"position"`       // number of organic results above the feature
+	Sidebar  bool   `json:"sidebar"`        // the feature is beside the results
+	Page     int    `json:"page,omitempty"` // page of the feature, from 0
+}
+
+// serpFeatures are the known features with their containers, looked up in
+// this order: an element inside a recognised feature isn't another feature
+var serpFeatures = []struct {
+	name     string
+	selector string
+}{
+	{"knowledge_panel", ".kp-wholepage, .knowledge-panel, .kp-blk, .osrp-blk"},
+	{"local_pack", ".local-pack, #lu_map, .rllt__link, .VkpGBb"},
+	{"travel", ".flights, .gws-flights, [data-flt-ve], .LQQ1Bd, [data-attrid*=transit]"},
+	{"people_also_ask", ".related-question-pair, [data-initq], div[jsname=N760b]"},
+	{"top_stories", ".top-stories, [aria-label='Top stories'], [aria-label='À la une'], [aria-label='Top-Meldungen']"},
+	{"videos", "video-voyager, .video-pack, [aria-label='Videos'], [aria-label='Vidéos']"},
+	{"images", "#imagebox_bigimages, #iur, .images_table"},
+	{"carousel", "g-scrolling-carousel, .kc_carousel"},
+}
+
+// featureNames returns the names of the known features
+func featureNames() []string {
+	names := make([]string, 0, len(serpFeatures))
+	for _, f := range serpFeatures {
+		names = append(names, f.name)
+	}
+	return names
+}
+
+// organicNodes returns the organic results of a page for any layout
+func organicNodes(doc *goquery.Document) *goquery.Selection {
+	if g := doc.Find("#search div.g, #ires div.g"); g.Length() > 0 {
+		return g
+	}
+	return doc.Find("div#ires cite")
+}
+
+// detectFeatures returns the features of the page in page order. A feature
+// made of several elements (questions of people also ask) is counted once
+func detectFeatures(doc *goquery.Document) []Feature {
+	all := doc.Find("*")
+	organic := make([]int, 0)
+	organicNodes(doc).Each(func(i int, s *goquery.Selection) {
+		organic = append(organic, all.IndexOfSelection(s))
+	})
+
+	type found struct {
+		feature Feature
+		index   int
+	}
+	features := make([]found, 0)
+	recognised := &goquery.Selection{}
+	for _, f := range serpFeatures {
+		previous := -1
+		doc.Find(f.selector).Each(func(i int, s *goquery.Selection) {
+			if recognised.IndexOfSelection(s) >= 0 || s.Parents().FilterSelection(recognised).Length() > 0 {
+				return
+			}
+			index := all.IndexOfSelection(s)
+			position := 0
+			for _, o := range organic {
+				if o < index {
+					position++
+				}
+			}
+			recognised = recognised.AddSelection(s)
+			// consecutive elements of a feature with no organic result in
+			// between are the same feature
+			if previous >= 0 && features[previous].feature.Position == position {
+				return
+			}
+			features = append(features, found{
+				feature: Feature{Name: f.name, Position: position, Sidebar: s.Closest("#rhs").Length() > 0},
+				index:   index,
+			})
+			previous = len(features) - 1
+		})
+	}
+
+	sort.SliceStable(features, func(i, j int) bool {
+		return features[i].index < features[j].index
+	})
+	result := make([]Feature, 0, len(features))
+	for _, f := range features {
+		result = append(result, f.feature)
+	}
+	return result
+}
@@ -0,0 +1,45 @@
+package scraper
+
+import (
+	"context"
+	"time"
+)
+
+// Budget spreads requests to google over the minute: one request every
+// minute/rpm at most, whatever the number of scrapers sharing it. It is safe
+// for concurrent use
+type Budget struct {
+	ticker *time.Ticker
+}
+
+// NewBudget returns a budget of rpm requests per minute, nil (no limit) when
+// rpm is 0. Stop releases it
+func NewBudget(rpm int) *Budget {
+	if rpm <= 0 {
+		return nil
+	}
+	return &Budget{ticker: time.NewTicker(time.Minute / time.Duration(rpm))}
+}
+
+// Wait blocks until a request can be sent or ctx is done, whose error is then
+// returned. Ticks aren't kept while nobody waits: an idle budget doesn't allow
+// a burst
+func (b *Budget) Wait(ctx context.Context) error {
+	if b == nil {
+		return nil
+	}
+	select {
+	case <-ctx.Done():
+		return ctx.Err()
+	case <-b.ticker.C:
+		return nil
+	}
+}
+
+// Stop releases the budget, its waiters are then released by their context only
+func (b *Budget) Stop() {
+	if b == nil {
+		return
+	}
+	b.ticker.Stop()
+}
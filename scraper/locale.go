@@ -0,0 +1,105 @@
+package scraper
+
+import (
+	"encoding/base64"
+	"errors"
+	"net/url"
+	"regexp"
+	"strings"
+)
+
+// adLabels are the labels of sponsored results for each language, in their
+// normalised form (see normaliseLabel)
+var adLabels = map[string][]string{
+	"fr": {"annonce", "annonces", "sponsorisé"},
+	"de": {"anzeige", "anzeigen", "gesponsert"},
+	"es": {"anuncio", "anuncios", "patrocinado"},
+	"it": {"annuncio", "annunci", "sponsorizzato"},
+	"en": {"ad", "ads", "sponsored"},
+}
+
+// domainLanguages gives the language of the google country domains
+var domainLanguages = map[string]string{
+	"google.fr":    "fr",
+	"google.de":    "de",
+	"google.at":    "de",
+	"google.es":    "es",
+	"google.it":    "it",
+	"google.co.uk": "en",
+	"google.ie":    "en",
+}
+
+// googleDomainRE matches the google country domains: google.fr, google.co.uk,
+// google.com.br...
+var googleDomainRE = regexp.MustCompile(`^google(\.[a-z]{2,3}){1,2}$`)
+
+// CheckGoogleDomain returns an error if domain isn't a google country domain
+// (without www.). Other hosts would be requested with the options of google
+func CheckGoogleDomain(domain string) error {
+	if !googleDomainRE.MatchString(domain) {
+		return errors.New("invalid google domain " + domain + " (google.fr, google.co.uk...)")
+	}
+	return nil
+}
+
+// pageLanguage returns the language of a google result page: the hl
+// parameter of its url, else the language of its country domain, else ""
+func pageLanguage(URL *url.URL) string {
+	if hl := strings.ToLower(URL.Query().Get("hl")); hl != "" {
+		// "fr-FR" or "fr"
+		return strings.SplitN(hl, "-", 2)[0]
+	}
+	return domainLanguages[strings.TrimPrefix(URL.Hostname(), "www.")]
+}
+
+// adLabelsFor returns the labels of sponsored results of a language, or the
+// labels of every known language when it is unknown (e.g. google.com)
+func adLabelsFor(lang string) []string {
+	if labels, ok := adLabels[lang]; ok {
+		return labels
+	}
+	all := make([]string, 0)
+	for _, labels := range adLabels {
+		all = append(all, labels...)
+	}
+	return all
+}
+
+// normaliseLabel lowers the case and collapses the whitespaces of a label
+func normaliseLabel(text string) string {
+	return strings.Join(strings.Fields(strings.ToLower(text)), " ")
+}
+
+// splitAdLabel splits the text of a label element on the "·" separator used
+// by the "Ad·www.oui.sncf/train" style labels. It returns the normalised
+// label and the text following the separator
+func splitAdLabel(text string) (label string, rest string) {
+	parts := strings.SplitN(text, "·", 2)
+	label = strings.TrimSuffix(normaliseLabel(parts[0]), ":")
+	if len(parts) == 2 {
+		rest = strings.TrimSpace(parts[1])
+	}
+	return strings.TrimSpace(label), rest
+}
+
+// isAdLabel returns true if the normalised label is one of labels
+func isAdLabel(label string, labels []string) bool {
+	for _, l := range labels {
+		if label == l {
+			return true
+		}
+	}
+	return false
+}
+
+// uuleAlphabet gives the key of the length of a location name in uule
+const uuleAlphabet = "ABCDEFGHIJKLMNOPQRSTUVWXYZabcdefghijklmnopqrstuvwxyz0123456789-_"
+
+// uule encodes the canonical name of a location ("Paris,Ile-de-France,France")
+// for the uule parameter of google
+func uule(location string) (string, error) {
+	if len(location) == 0 || len(location) >= len(uuleAlphabet) {
+		return "", errors.New("location name must have between 1 and 63 bytes: " + location)
+	}
+	return "w+CAIQICI" + string(uuleAlphabet[len(location)]) + base64.StdEncoding.EncodeToString([]byte(location)), nil
+}
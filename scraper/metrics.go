@@ -0,0 +1,135 @@
+package scraper
+
+import (
+	"log"
+	"strings"
+	"time"
+)
+
+// MetricSink receives the metrics of the scrapper (graphite, csv, influxdb...)
+type MetricSink interface {
+	// Send a metric measured at t. The name of the metric is prefixed
+	Send(metric string, value interface{}, t time.Time) error
+	// Flush buffered metrics
+	Flush() error
+	// Close the sink
+	Close() error
+}
+
+// Metrics sends the metrics of a result, with the same prefix, to all sinks
+type Metrics struct {
+	sinks  []MetricSink
+	prefix string
+}
+
+// NewMetrics creates a new instance of metrics
+func NewMetrics(prefix string, sinks []MetricSink) Metrics {
+	return Metrics{
+		sinks:  sinks,
+		prefix: prefix,
+	}
+}
+
+// Close flushes the sinks used by metrics. Sinks are shared by every metrics
+// of a run and closed with closeSinks
+func (m *Metrics) Close() {
+	for _, sink := range m.sinks {
+		if err := sink.Flush(); err != nil {
+			log.Printf("can't flush metrics: %v", err)
+		}
+	}
+}
+
+// Send new metric to all sinks
+func (m *Metrics) Send(metric string, value interface{}) {
+	m.SendAt(time.Now(), metric, value)
+}
+
+// SendAt sends a metric measured at t, used when replaying saved pages
+func (m *Metrics) SendAt(t time.Time, metric string, value interface{}) {
+	for _, sink := range m.sinks {
+		if err := sink.Send(m.prefix+"."+metric, value, t); err != nil {
+			log.Printf("can't send metric %s.%s: %v", m.prefix, metric, err)
+		}
+	}
+}
+
+// Publish computes the waste and sends the metrics of a result, measured at
+// the time of the result
+func Publish(met *Metrics, result *Result, watch []WatchedDomain) {
+	send := func(metric string, value interface{}) {
+		met.SendAt(result.Time, metric, value)
+	}
+
+	// ranks of the ads of the next pages would overwrite the first page ones
+	ads := result.FirstPageSEA()
+
+	// send to graphite
+	send("sea.count", len(ads))
+	send("seo.count", len(result.SEO))
+	for i, w := range watch {
+		wasted := result.Waste[w.Domain]
+		// no density without SEO results
+		density, dense := result.SEODensity[w.Domain]
+		if i == 0 {
+			// the first watched domain keeps the historical metric names
+			send("waste", wasted)
+			if dense {
+				send("seo.density", density)
+			}
+		}
+		domain := strings.Replace(w.Domain, ".", "_", -1)
+		send("watch."+domain+".waste", wasted)
+		send("watch."+domain+".waste.score", result.WasteScore[w.Domain].Score)
+		if dense {
+			send("watch."+domain+".seo.density", density)
+		}
+		send("watch."+domain+".seo.count", result.SEOCount[w.Domain])
+		send("watch."+domain+".seo.first", result.SEOFirst[w.Domain])
+		send("watch."+domain+".sea.first", result.SEAFirst[w.Domain])
+	}
+
+	blocks := make(map[string]int)
+	for _, sea := range ads {
+		blocks[sea.Block]++
+		domain := strings.Replace(sea.Domain, ".", "_", -1)
+		send("sea."+sea.Block+"."+domain, sea.BlockRank)
+	}
+	for _, block := range adBlockTypes {
+		send("sea."+block+".count", blocks[block])
+	}
+
+	// features: presence and number of organic results above the first one
+	for _, name := range featureNames() {
+		position := -1
+		for _, f := range result.Features {
+			if f.Name == name {
+				position = f.Position
+				break
+			}
+		}
+		present := 0
+		if position >= 0 {
+			present = 1
+		}
+		send("feature."+name+".present", present)
+		send("feature."+name+".position", position)
+	}
+
+	domains := make(map[string]int)
+	for _, seo := range result.SEO {
+		if _, ok := domains[seo.Domain]; ok {
+			continue
+		} else {
+			domains[seo.Domain] = seo.Position
+		}
+		domain := strings.Replace(seo.Domain, ".", "_", -1)
+		send("seo."+domain, seo.Position)
+	}
+}
+
+// MetricName makes a string usable as a graphite metric node
+func MetricName(s string) string {
+	s = strings.ToLower(strings.TrimSpace(s))
+	return strings.NewReplacer(" ", "_", ".", "_", "/", "_").Replace(s)
+}
@@ -0,0 +1,109 @@
+package scraper
+
+import (
+	"regexp"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// OrganicDetail is the content of an organic result, as far as the layout
+// shows it
+type OrganicDetail struct {
+	Title      string   `json:"title,omitempty"`
+	URL        string   `json:"url,omitempty"`        // target url, behind the google redirection
+	Snippet    string   `json:"snippet,omitempty"`    // text below the title
+	Breadcrumb string   `json:"breadcrumb,omitempty"` // url shown in the result, "www.oui.sncf › train"
+	Rating     string   `json:"rating,omitempty"`     // e.g. "4,5"
+	Reviews    string   `json:"reviews,omitempty"`    // number of reviews of the rating
+	Date       string   `json:"date,omitempty"`       // date shown before the snippet
+	Rich       []string `json:"rich,omitempty"`       // rich result markers: rating, date, sitelinks, image, video
+}
+
+// organicSelectors locate the parts of an organic result. Several layouts are
+// listed for each part, the first found is kept
+var organicSelectors = struct {
+	title, snippet, breadcrumb, rating, date, sitelinks, image, video string
+}{
+	title:      "h3",
+	snippet:    ".st, .IsZvec, .aCOpRe, .VwiC3b",
+	breadcrumb: "cite",
+	rating:     ".slp, g-review-stars, [aria-label*=Note], [aria-label*=Rated]",
+	date:       ".st .f, span.f, .MUxGbd.wuQ4Ob",
+	sitelinks:  "table.nrgt, .sld, .usJj9c",
+	image:      "g-img, img",
+	video:      ".vdur, .J1mWY",
+}
+
+var (
+	// ratingRE matches a rating: "Note : 4,5 - 1 234 avis", "Rating: 4.5 - 120 reviews"
+	ratingRE = regexp.MustCompile(`(\d(?:[.,]\d)?)\s*(?:/\s*5)?(?:.*?(\d[\d\s.,]*)\s*(?:avis|votes?|reviews?|Bewertungen|opiniones|recensioni))?`)
+	// dateRE matches a date at the start of a snippet: "21 juin 2018 - ", "Jun 21, 2018 ..."
+	dateRE = regexp.MustCompile(`^(\d{1,2}[\s.]+\p{L}+\.?\s+\d{4}|\p{L}+\.?\s+\d{1,2},\s+\d{4}|\d{1,2}/\d{1,2}/\d{4})\s*[-—·]`)
+)
+
+// extractOrganic reads the content of an organic result container. href is
+// the link of the result, "" to look for it in the container
+func extractOrganic(result *goquery.Selection, href string) *OrganicDetail {
+	detail := &OrganicDetail{
+		Title:      cleanText(result.Find(organicSelectors.title).First()),
+		Snippet:    cleanText(result.Find(organicSelectors.snippet).First()),
+		Breadcrumb: cleanText(result.Find(organicSelectors.breadcrumb).First()),
+	}
+
+	// target url: the link of the title, else the first link leaving google
+	if href == "" {
+		href, _ = result.Find("h3").First().ParentsFiltered("a[href]").First().Attr("href")
+	}
+	if detail.URL = landingURL(href); detail.URL == "" {
+		result.Find("a[href]").EachWithBreak(func(i int, link *goquery.Selection) bool {
+			href, _ := link.Attr("href")
+			detail.URL = landingURL(href)
+			return detail.URL == ""
+		})
+	}
+	if detail.URL == "" {
+		// the 2018 layout shows the full url in the breadcrumb
+		if link := strings.Fields(detail.Breadcrumb); len(link) > 0 {
+			detail.URL = landingURL(link[0])
+		}
+	}
+
+	if rating := result.Find(organicSelectors.rating).First(); rating.Length() > 0 {
+		text := cleanText(rating)
+		if label, ok := rating.Attr("aria-label"); ok && text == "" {
+			text = label
+		}
+		if m := ratingRE.FindStringSubmatch(text); m != nil {
+			detail.Rating = m[1]
+			detail.Reviews = strings.Join(strings.Fields(m[2]), "")
+			detail.Rich = append(detail.Rich, "rating")
+		}
+	}
+	if date := cleanText(result.Find(organicSelectors.date).First()); date != "" {
+		detail.Date = strings.TrimRight(date, " -—·")
+	} else if m := dateRE.FindStringSubmatch(detail.Snippet); m != nil {
+		detail.Date = m[1]
+	}
+	if detail.Date != "" {
+		detail.Rich = append(detail.Rich, "date")
+	}
+	if result.Find(organicSelectors.sitelinks).Length() > 0 {
+		detail.Rich = append(detail.Rich, "sitelinks")
+	}
+	if result.Find(organicSelectors.video).Length() > 0 {
+		detail.Rich = append(detail.Rich, "video")
+	} else if result.Find(organicSelectors.image).Length() > 0 {
+		detail.Rich = append(detail.Rich, "image")
+	}
+	return detail
+}
+
+// organicContainer returns the element holding the whole organic result of a
+// link of the 2018 layout: its div.g, else the parent of the link
+func organicContainer(link *goquery.Selection) *goquery.Selection {
+	if g := link.Closest("div.g, li.g"); g.Length() > 0 {
+		return g
+	}
+	return link.Parent()
+}
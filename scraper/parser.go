@@ -0,0 +1,352 @@
+package scraper
+
+import (
+	"bytes"
+	"errors"
+	"fmt"
+	"log"
+	"net/url"
+	"strings"
+
+	"github.com/PuerkitoBio/goquery"
+)
+
+// SERPParser extracts SEA and SEO results from a google result page. Google
+// changes its markup often: each known layout has its own parser
+type SERPParser interface {
+	// Name of the layout, recorded in the result
+	Name() string
+	// Parse returns an error when the page doesn't match the layout
+	Parse(doc *goquery.Document, device string) (sea, seo []SearchResult, err error)
+}
+
+// errLayout is returned by a parser when the page doesn't look like its layout
+var errLayout = errors.New("page doesn't match the layout")
+
+// parsers returns the known layouts for a page language, tried in this order
+// by the "auto" parser
+func parsers(lang string) []SERPParser {
+	return []SERPParser{
+		v2018Parser{labels: adLabelsFor(lang)},
+		v2019Parser{},
+	}
+}
+
+// ParserNames returns the names accepted by -parser
+func ParserNames() []string {
+	names := []string{"auto"}
+	for _, p := range parsers("") {
+		names = append(names, p.Name())
+	}
+	return names
+}
+
+// SelectParsers returns the parsers to try for the name given by -parser and
+// the language of the page
+func SelectParsers(name string, lang string) ([]SERPParser, error) {
+	if name == "" || name == "auto" {
+		return parsers(lang), nil
+	}
+	for _, p := range parsers(lang) {
+		if p.Name() == name {
+			return []SERPParser{p}, nil
+		}
+	}
+	return nil, fmt.Errorf("unknown parser %q (available: %s)", name, strings.Join(ParserNames(), ", "))
+}
+
+// parseSERP runs the parsers in order and keeps the results of the first one
+// matching the page
+func parseSERP(candidates []SERPParser, doc *goquery.Document, device string) (name string, sea, seo []SearchResult, err error) {
+	for _, p := range candidates {
+		sea, seo, err = p.Parse(doc, device)
+		if err == nil {
+			rankBlocks(sea)
+			return p.Name(), sea, seo, nil
+		}
+		log.Printf("parser %s: %v", p.Name(), err)
+	}
+	return "", nil, nil, errors.New("no parser matched the page")
+}
+
+// adBlockTypes are the blocks of ads on a page
+var adBlockTypes = []string{"top", "bottom", "shopping", "sidebar"}
+
+// adBlocks are the containers of each ad block, looked up in this order from
+// an ad: a shopping unit may be in the top block or the sidebar
+var adBlocks = []struct {
+	selector string
+	block    string
+}{
+	{".commercial-unit-desktop-top, .commercial-unit-desktop-rhs, .commercial-unit-mobile-top, .cu-container, .pla-unit", "shopping"},
+	{"#rhs, #mbEnd", "sidebar"},
+	{"#tadsb, #bottomads", "bottom"},
+	{"#tads, #tvcap", "top"},
+}
+
+// adBlock returns the block of an ad: its known container or, without one,
+// "bottom" when it is after the organic results and "top" otherwise
+func adBlock(doc *goquery.Document, ad *goquery.Selection, organic *goquery.Selection) string {
+	for _, b := range adBlocks {
+		if ad.Closest(b.selector).Length() > 0 {
+			return b.block
+		}
+	}
+	if organic.Length() > 0 {
+		all := doc.Find("*")
+		end := organic.Find("*").Last()
+		if end.Length() == 0 {
+			end = organic
+		}
+		if all.IndexOfSelection(ad) > all.IndexOfSelection(end) {
+			return "bottom"
+		}
+	}
+	return "top"
+}
+
+// rankBlocks sets the rank of each ad in its block
+func rankBlocks(sea []SearchResult) {
+	ranks := make(map[string]int)
+	for i := range sea {
+		sea[i].BlockRank = ranks[sea[i].Block]
+		ranks[sea[i].Block]++
+	}
+}
+
+// hostname returns the host of a raw link found in the page ("www.oui.sncf › train"
+// or "https://www.oui.sncf/train")
+func hostname(raw string) (string, error) {
+	link := strings.TrimSpace(raw)
+	if split := strings.Fields(link); len(split) > 0 {
+		link = split[0]
+	}
+	if !strings.HasPrefix(link, "http") {
+		link = "http://" + link
+	}
+	URL, err := url.ParseRequestURI(link)
+	if err != nil {
+		return "", err
+	}
+	if URL.Hostname() == "" {
+		return "", errors.New("no host in " + raw)
+	}
+	return URL.Hostname(), nil
+}
+
+// v2018Parser reads the layout of 2018: each ad is labelled by a <span>Annonce</span>
+// (or its translation) followed by its display url, organic results are the
+// <cite> (desktop) or <span> (mobile) of div#ires
+type v2018Parser struct {
+	labels []string // normalised labels of sponsored results
+}
+
+func (v2018Parser) Name() string {
+	return "v2018"
+}
+
+func (parser v2018Parser) Parse(doc *goquery.Document, device string) (sea, seo []SearchResult, err error) {
+	ires := doc.Find("div[id=ires]")
+	if ires.Length() == 0 {
+		return nil, nil, errLayout
+	}
+
+	// SEA links
+	sea = make([]SearchResult, 0)
+	pos := -1
+	doc.Find("body span").Each(func(p int, span *goquery.Selection) {
+		label, rest := splitAdLabel(span.Text())
+		if !isAdLabel(label, parser.labels) {
+			return
+		}
+		pos = pos + 1
+		found := false
+		block := adBlock(doc, span, ires)
+		container := adContainer(span)
+		if domain, err := hostname(rest); rest != "" && err == nil {
+			// "Ad·www.oui.sncf/train": the display url follows the label
+			sea = append(sea, SearchResult{
+				Position:    pos,
+				CSSSelector: "span",
+				Raw:         rest,
+				Domain:      domain,
+				Block:       block,
+				Ad:          extractAd(container, rest),
+			})
+			return
+		}
+		span.Siblings().EachWithBreak(func(p int, sibling *goquery.Selection) bool {
+			domain := sibling.Text()
+			if !strings.HasPrefix(domain, "http") {
+				domain = "http://" + domain
+			}
+			URL, err := url.ParseRequestURI(domain)
+			if err == nil {
+				// found domain of the promoted link
+				sea = append(sea, SearchResult{
+					Position:    pos,
+					CSSSelector: "span",
+					Raw:         sibling.Text(),
+					Domain:      URL.Hostname(),
+					Block:       block,
+					Ad:          extractAd(container, sibling.Text()),
+				})
+				found = true
+				return false
+			}
+			return true
+		})
+		if !found {
+			sea = append(sea, SearchResult{
+				Position:    pos,
+				CSSSelector: "span",
+				Raw:         "not found",
+				Domain:      "unparseable",
+				Block:       block,
+				Ad:          extractAd(container, ""),
+			})
+		}
+	})
+
+	// SEO results
+	seo = make([]SearchResult, 0)
+	pos = -1
+	span := "cite" // <span> or <cite> which contains found link by SEO
+	if device == "mobile" {
+		span = "span"
+	}
+	ires.Find(span).Each(func(p int, span *goquery.Selection) {
+		detail := extractOrganic(organicContainer(span), "")
+		split := strings.Split(span.Text(), " ")
+		URL, err := url.ParseRequestURI(split[0])
+		if err != nil && detail.URL != "" && detail.Breadcrumb == strings.TrimSpace(span.Text()) {
+			// "www.oui.sncf › train": the link of the result gives the url
+			URL, err = url.ParseRequestURI(detail.URL)
+		}
+		if err != nil {
+			log.Printf("can't parse span url %s: %v", span.Text(), err)
+			return
+		}
+		pos = pos + 1
+		// found not promoted domain (seo)
+		seo = append(seo, SearchResult{
+			Position:    pos,
+			CSSSelector: "div[id=ires]",
+			Raw:         span.Text(),
+			Domain:      URL.Hostname(),
+			Organic:     detail,
+		})
+	})
+	return sea, seo, nil
+}
+
+// v2019Parser reads the layout of 2019: ads are the li.ads-ad of the top
+// (#tads), bottom (#tadsb) and sidebar (#rhs) blocks and the .pla-unit of the
+// shopping units, organic results are the div.g of #search
+type v2019Parser struct{}
+
+func (v2019Parser) Name() string {
+	return "v2019"
+}
+
+func (v2019Parser) Parse(doc *goquery.Document, device string) (sea, seo []SearchResult, err error) {
+	search := doc.Find("#search")
+	if search.Length() == 0 || search.Find("div.g").Length() == 0 {
+		return nil, nil, errLayout
+	}
+
+	// SEA links
+	sea = make([]SearchResult, 0)
+	doc.Find("#tads li.ads-ad, #tadsb li.ads-ad, #rhs li.ads-ad, .pla-unit").Each(func(p int, ad *goquery.Selection) {
+		selector := "li.ads-ad"
+		raw := strings.TrimSpace(ad.Find("cite").First().Text())
+		creative := extractAd(ad, raw)
+		if ad.Is(".pla-unit") {
+			// shopping units show the merchant, the landing url gives its domain
+			selector = ".pla-unit"
+			if raw == "" {
+				raw = creative.LandingURL
+			}
+		}
+		domain, err := hostname(raw)
+		if err != nil {
+			raw, domain = "not found", "unparseable"
+		}
+		sea = append(sea, SearchResult{
+			Position:    len(sea),
+			CSSSelector: selector,
+			Raw:         raw,
+			Domain:      domain,
+			Block:       adBlock(doc, ad, search),
+			Ad:          creative,
+		})
+	})
+
+	// SEO results
+	seo = make([]SearchResult, 0)
+	search.Find("div.g").Each(func(p int, g *goquery.Selection) {
+		// nested div.g are parts of the same result
+		if g.ParentsFiltered("div.g").Length() > 0 {
+			return
+		}
+		detail := extractOrganic(g, "")
+		raw := detail.URL
+		domain, err := hostname(raw)
+		if err != nil {
+			raw = strings.TrimSpace(g.Find("cite").First().Text())
+			if domain, err = hostname(raw); err != nil {
+				return
+			}
+		}
+		seo = append(seo, SearchResult{
+			Position:    len(seo),
+			CSSSelector: "div.g",
+			Raw:         raw,
+			Domain:      domain,
+			Organic:     detail,
+		})
+	})
+	return sea, seo, nil
+}
+
+// AnalysePage extracts the results of one page of the keywords and appends
+// them to the result: organic positions continue those of the previous pages,
+// ads and features keep the page where they were found
+func AnalysePage(result *Result, body []byte, parser string, page int) error {
+	URL, err := url.Parse(result.URL)
+	if err != nil {
+		return err
+	}
+	candidates, err := SelectParsers(parser, pageLanguage(URL))
+	if err != nil {
+		return err
+	}
+	doc, err := goquery.NewDocumentFromReader(bytes.NewReader(body))
+	if err != nil {
+		return err
+	}
+	name, sea, seo, err := parseSERP(candidates, doc, result.Device)
+	if err != nil {
+		return err
+	}
+	if result.Parser == "" {
+		result.Parser = name
+	}
+	offset := len(result.SEO)
+	for i := range sea {
+		sea[i].Position += len(result.SEA)
+		sea[i].Page = page
+	}
+	for i := range seo {
+		seo[i].Position += offset
+		seo[i].Page = page
+	}
+	result.SEA = append(result.SEA, sea...)
+	result.SEO = append(result.SEO, seo...)
+	for _, f := range detectFeatures(doc) {
+		f.Position += offset
+		f.Page = page
+		result.Features = append(result.Features, f)
+	}
+	return nil
+}
@@ -0,0 +1,198 @@
+package scraper
+
+import (
+	"fmt"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/waste"
+)
+
+// Result is exported to be parsed by json
+type Result struct {
+	Keywords   string                 `json:"keywords"`          // keywords used for requesting google
+	URL        string                 `json:"url"`               // url used for requesting to google
+	UserAgent  string                 `json:"userAgent"`         // user agent used for requesting to google
+	Proxy      string                 `json:"proxy,omitempty"`   // proxy of the request to google, without credentials
+	Device     string                 `json:"mobile"`            // device from user agent ('mobile' or 'desktop')
+	Location   string                 `json:"location"`          // canonical name of the city of the search
+	Parser     string                 `json:"parser"`            // name of the layout parser which matched the page
+	Time       time.Time              `json:"time"`              // time of the request to google
+	Blocked    bool                   `json:"blocked,omitempty"` // google blocked the request (captcha, 429, 503): the result is empty
+	SEOCount   map[string]int         `json:"seoCount"`          // counter of appearance at SEO results for each watched domain
+	SEOFirst   map[string]int         `json:"seoFirst"`          // position for the first SEO result of each watched domain (-1 if absent)
+	SEAFirst   map[string]int         `json:"seaFirst"`          // position for the first SEA result of each watched domain (-1 if absent)
+	SEODensity map[string]float64     `json:"seoDensity"`        // share of SEO results of each watched domain (absent without SEO results)
+	Waste      map[string]int         `json:"waste"`             // 1 if bidding on the keywords is not necessary for the watched domain
+	WasteScore map[string]waste.Score `json:"wasteScore"`        // cannibalisation score of the ads of each watched domain, with its reason
+	SEO        []SearchResult         `json:"seo"`               // all SEO results
+	SEA        []SearchResult         `json:"sea"`               // all SEA results
+	Features   []Feature              `json:"features"`          // SERP features of the page (local pack, people also ask...) in page order
+}
+
+// Score computes counters, first positions, density and waste of watched domains
+func (gr *Result) Score(watch []WatchedDomain) {
+	for _, w := range watch {
+		gr.SEOCount[w.Domain] = 0
+		gr.SEOFirst[w.Domain] = -1
+		for _, seo := range gr.SEO {
+			if seo.Domain != w.Domain {
+				continue
+			}
+			gr.SEOCount[w.Domain] = gr.SEOCount[w.Domain] + 1
+			if gr.SEOFirst[w.Domain] < 0 {
+				gr.SEOFirst[w.Domain] = seo.Position
+			}
+		}
+		gr.SEAFirst[w.Domain] = gr.firstSEA(w.Domain)
+		if len(gr.SEO) > 0 {
+			gr.SEODensity[w.Domain] = float64(gr.SEOCount[w.Domain]) / float64(len(gr.SEO))
+		}
+		thresholds := waste.DefaultThresholds
+		if w.Waste != nil {
+			thresholds = *w.Waste
+		}
+		score := waste.Compute(gr.wasteSERP(), w.Domain, w.Siblings, thresholds)
+		gr.WasteScore[w.Domain] = score
+		gr.Waste[w.Domain] = 0
+		if score.Waste {
+			gr.Waste[w.Domain] = 1
+		}
+	}
+}
+
+// FirstPageSEA returns the SEA results of the first page. Ads of the next
+// pages are beside other organic results, their ranks aren't comparable
+func (gr Result) FirstPageSEA() []SearchResult {
+	ads := make([]SearchResult, 0, len(gr.SEA))
+	for _, sea := range gr.SEA {
+		if sea.Page == 0 {
+			ads = append(ads, sea)
+		}
+	}
+	return ads
+}
+
+// firstSEA returns the position of the first SEA result of domain on the
+// first page, -1 if absent
+func (gr Result) firstSEA(domain string) int {
+	for _, sea := range gr.FirstPageSEA() {
+		if sea.Domain == domain {
+			return sea.Position
+		}
+	}
+	return -1
+}
+
+// wasteSERP converts the results of the page for the waste package. Only the
+// text ads of the top and bottom blocks of the first page are bid on the
+// keywords, shopping and sidebar ads are left out
+func (gr Result) wasteSERP() waste.SERP {
+	serp := waste.SERP{Ads: make([]waste.Entry, 0, len(gr.SEA)), Organic: make([]waste.Entry, 0, len(gr.SEO))}
+	for _, sea := range gr.FirstPageSEA() {
+		switch sea.Block {
+		case "", "top":
+			serp.Ads = append(serp.Ads, waste.Entry{Domain: sea.Domain, Position: sea.Position, Block: waste.Top})
+		case "bottom":
+			serp.Ads = append(serp.Ads, waste.Entry{Domain: sea.Domain, Position: sea.Position, Block: waste.Bottom})
+		}
+	}
+	for _, seo := range gr.SEO {
+		serp.Organic = append(serp.Organic, waste.Entry{Domain: seo.Domain, Position: seo.Position})
+	}
+	return serp
+}
+
+// Parsed returns true when the result holds the results of a page: neither
+// blocked by google nor matched by no layout parser (consent page, new
+// layout...). Other results are empty, their counts aren't zeros
+func (gr Result) Parsed() bool {
+	return !gr.Blocked && gr.Parser != ""
+}
+
+// Print result to stdout
+func (gr Result) Print() {
+	fmt.Println("results:")
+	fmt.Printf("keywords: %s, url: %s, device: %s, user agent: %s, parser: %s\n", gr.Keywords, gr.URL, gr.Device, gr.UserAgent, gr.Parser)
+	if gr.Blocked {
+		fmt.Println("blocked by google")
+	} else if gr.Parser == "" {
+		fmt.Println("no parser matched the page")
+	}
+	fmt.Println("sea:")
+	for _, sea := range gr.SEA {
+		fmt.Printf("%d - %s %d - %s - %s\n", sea.Position, sea.Block, sea.BlockRank, sea.Domain, sea.Raw)
+		if sea.Ad != nil && (sea.Ad.Headline != "" || sea.Ad.LandingURL != "") {
+			fmt.Printf("    %s -> %s\n", sea.Ad.Headline, sea.Ad.LandingURL)
+		}
+	}
+	if len(gr.Features) > 0 {
+		fmt.Println("features:")
+		for _, f := range gr.Features {
+			fmt.Printf("%d - %s\n", f.Position, f.Name)
+		}
+	}
+	fmt.Println("seo:")
+	for _, seo := range gr.SEO {
+		fmt.Printf("%d - %s - %s\n", seo.Position, seo.Domain, seo.Raw)
+		if seo.Organic != nil && seo.Organic.Title != "" {
+			fmt.Printf("    %s -> %s\n", seo.Organic.Title, seo.Organic.URL)
+		}
+	}
+}
+
+// SearchResult store a parsed page result
+type SearchResult struct {
+	Position    int            `json:"position"`
+	CSSSelector string         `json:"cssSelector"`
+	Raw         string         `json:"raw"`
+	Block       string         `json:"block,omitempty"` // block of an ad: top, bottom, shopping or sidebar
+	BlockRank   int            `json:"blockRank"`       // position of an ad in its block
+	Domain      string         `json:"domain"`
+	Ad          *AdCreative    `json:"ad,omitempty"`      // content of an ad
+	Organic     *OrganicDetail `json:"organic,omitempty"` // content of an organic result
+	Page        int            `json:"page,omitempty"`    // page of the result, from 0
+}
+
+// WatchedDomain is a domain monitored in SEA and SEO results. Siblings are
+// other domains which count as ours: they don't break the waste rule when
+// they are between our ad and our first organic result
+type WatchedDomain struct {
+	Domain   string            `json:"domain"`
+	Siblings []string          `json:"siblings,omitempty"`
+	Waste    *waste.Thresholds `json:"waste,omitempty"` // thresholds of the waste of the domain, those of the config by default
+}
+
+// IsSibling returns true if domain counts as the watched domain
+func (w WatchedDomain) IsSibling(domain string) bool {
+	if domain == w.Domain {
+		return true
+	}
+	for _, sibling := range w.Siblings {
+		if domain == sibling {
+			return true
+		}
+	}
+	return false
+}
+
+// NewResult creates an empty result for keywords
+func NewResult(keywords string, watch []WatchedDomain) *Result {
+	result := &Result{
+		Keywords:   keywords,
+		SEOCount:   make(map[string]int),
+		SEOFirst:   make(map[string]int),
+		SEAFirst:   make(map[string]int),
+		SEODensity: make(map[string]float64),
+		Waste:      make(map[string]int),
+		WasteScore: make(map[string]waste.Score),
+		SEO:        make([]SearchResult, 0),
+		SEA:        make([]SearchResult, 0),
+	}
+	for _, w := range watch {
+		result.SEOCount[w.Domain] = 0
+		result.SEOFirst[w.Domain] = -1
+		result.SEAFirst[w.Domain] = -1
+		result.Waste[w.Domain] = 0
+	}
+	return result
+}
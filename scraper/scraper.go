@@ -0,0 +1,379 @@
+// Package scraper requests google for keywords and extracts the SEA and SEO
+// results of the page, with the score of the watched domains. A Scraper is
+// built once from its options and used for any number of keywords, each
+// scrape has its own collector and result.
+package scraper
+
+import (
+	"context"
+	"errors"
+	"fmt"
+	"log"
+	"math/rand"
+	"net/http"
+	"net/url"
+	"strconv"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/gocolly/colly"
+	"github.com/mssola/user_agent"
+)
+
+// Options of a Scraper
+type Options struct {
+	Device       string // device of the user agent: desktop (default) or mobile
+	UserAgent    string // user agent of the requests, a random one of the device by default
+	Parser       string // name of the SERP layout parser, "auto" (default) tries all of them
+	GoogleDomain string // google country domain (google.fr, google.de...), google.com by default
+	Language     string // interface language of google (hl parameter)
+	Country      string // country of the results (gl parameter)
+	Location     string // canonical name of the city of the search, e.g. "Lyon,Auvergne-Rhone-Alpes,France"
+	Depth        int    // number of result pages scraped for each keywords, 1 by default
+	Watch        []WatchedDomain
+
+	Delay  time.Duration // delay between two requests of a scrape
+	Jitter time.Duration // maximum random delay added to the delay
+	Budget *Budget       // requests per minute, shared with other scrapers, nil for no limit
+
+	// a scrape blocked by google is retried up to Retries times with another
+	// user agent and proxy, after Backoff (30s by default) doubled at each retry
+	// up to an hour
+	Retries int
+	Backoff time.Duration
+
+	// Proxies of the requests, shared with other scrapers, nil for direct requests
+	Proxies *ProxyPool
+
+	// Sinks receive the metrics of each result under Prefix. Each keywords
+	// gets its own metrics when KeywordsMetrics is set
+	Sinks           []MetricSink
+	Prefix          string
+	KeywordsMetrics bool
+
+	// OnPage is called with every fetched page (e.g. to save it), nil to ignore
+	OnPage func(result *Result, body []byte, page int)
+}
+
+// Scraper requests google with its options. Scrape is safe for concurrent use
+type Scraper struct {
+	opts Options
+
+	// sinks aren't safe for concurrent use: results are published one at a
+	// time, by this scraper and the ones derived from it (see With)
+	mutex *sync.Mutex
+}
+
+// New checks the options and returns their scraper
+func New(opts Options) (*Scraper, error) {
+	if opts.Device == "" {
+		opts.Device = "desktop"
+	}
+	if opts.Device != "desktop" && opts.Device != "mobile" {
+		return nil, errors.New("unknown device " + opts.Device + " (available: desktop, mobile)")
+	}
+	if opts.Parser == "" {
+		opts.Parser = "auto"
+	}
+	if _, err := SelectParsers(opts.Parser, ""); err != nil {
+		return nil, err
+	}
+	if opts.GoogleDomain == "" {
+		opts.GoogleDomain = "google.com"
+	}
+	if err := CheckGoogleDomain(opts.GoogleDomain); err != nil {
+		return nil, err
+	}
+	if opts.Depth == 0 {
+		opts.Depth = 1
+	}
+	if opts.Depth < 0 {
+		return nil, fmt.Errorf("invalid depth %d, at least 1 page is scraped", opts.Depth)
+	}
+	if opts.Location != "" {
+		if _, err := uule(opts.Location); err != nil {
+			return nil, err
+		}
+	}
+	if opts.Retries < 0 {
+		return nil, fmt.Errorf("invalid number of retries %d", opts.Retries)
+	}
+	if opts.Backoff < 0 {
+		return nil, fmt.Errorf("invalid backoff %v", opts.Backoff)
+	}
+	if opts.Backoff == 0 {
+		opts.Backoff = 30 * time.Second
+	}
+	return &Scraper{opts: opts, mutex: &sync.Mutex{}}, nil
+}
+
+// With returns a scraper of the options of s changed by change (device,
+// location... of a search). It shares the budget, the proxies and the sinks
+// of s, and publishes its results one at a time with those of s
+func (s *Scraper) With(change func(*Options)) (*Scraper, error) {
+	opts := s.opts
+	change(&opts)
+	derived, err := New(opts)
+	if err != nil {
+		return nil, err
+	}
+	derived.mutex = s.mutex
+	return derived, nil
+}
+
+// MetricsPrefix returns the prefix of the metrics of a result
+func (s *Scraper) MetricsPrefix(result *Result) string {
+	prefix := s.opts.Prefix + "." + result.Device
+	if result.Location != "" {
+		// metrics of each location are kept apart
+		prefix = prefix + "." + MetricName(result.Location)
+	}
+	if s.opts.KeywordsMetrics {
+		prefix = prefix + "." + MetricName(result.Keywords)
+	}
+	return prefix
+}
+
+// Scrape requests google for keywords, following the pagination up to the
+// depth of the options, and returns the scored result. The result is
+// returned with the error of the first page, a failure of the next pages only
+// ends the pagination. Every page counts in the health of its proxy. A page
+// matching no parser is an ErrParse: the result is returned without
+// publishing its empty counts. A blocked scrape is retried with backoff, at
+// last its result is Blocked and only the blocked metric is sent
+func (s *Scraper) Scrape(ctx context.Context, keywords string) (*Result, error) {
+	var result *Result
+	var met Metrics
+	var err error
+	blocked := 0
+	used := make([]*proxy, 0, 1)
+	for attempt := 0; ; attempt++ {
+		userAgent := s.opts.UserAgent
+		if userAgent == "" {
+			// each retry comes with another user agent
+			if s.opts.Device == "mobile" {
+				userAgent = randMobile()
+			} else {
+				userAgent = randDesktop()
+			}
+		}
+		proxy := s.opts.Proxies.pick()
+		result, met, err = s.visit(ctx, keywords, userAgent, proxy)
+		if proxy != nil {
+			used = append(used, proxy)
+		}
+		if KindOf(err) != ErrBlocked {
+			break
+		}
+		blocked++
+		if attempt >= s.opts.Retries {
+			break
+		}
+		wait := s.backoff(attempt)
+		log.Printf("scrap of %q blocked, retry %d/%d in %v: %v", keywords, attempt+1, s.opts.Retries, wait, err)
+		select {
+		case <-ctx.Done():
+			return result, err
+		case <-time.After(wait):
+		}
+	}
+
+	// blocked and unparsed scrapes send no zero counts, only the number of
+	// blocked attempts
+	published := !result.Blocked && err == nil
+	if len(s.opts.Sinks) > 0 && !result.Time.IsZero() && (published || blocked > 0) {
+		s.mutex.Lock()
+		if published {
+			Publish(&met, result, s.opts.Watch)
+		}
+		met.SendAt(result.Time, "blocked", blocked)
+		for _, proxy := range used {
+			met.SendAt(result.Time, "proxy."+MetricName(strings.Replace(proxy.url.Host, ":", "_", -1))+".block.rate", s.opts.Proxies.blockRate(proxy))
+		}
+		met.Close()
+		s.mutex.Unlock()
+	}
+	return result, err
+}
+
+// maxBackoff caps the wait before a retry, whatever the number of retries
+const maxBackoff = time.Hour
+
+// backoff returns the wait before the retry of attempt: the backoff of the
+// options doubled at each attempt, up to maxBackoff, plus a random half
+func (s *Scraper) backoff(attempt int) time.Duration {
+	wait := s.opts.Backoff
+	for i := 0; i < attempt && wait < maxBackoff; i++ {
+		wait *= 2
+	}
+	if wait > maxBackoff {
+		wait = maxBackoff
+	}
+	return wait + time.Duration(rand.Int63n(int64(wait)/2+1))
+}
+
+// visit requests google once for keywords with a user agent, through proxy if
+// not nil. It returns the scored result and its metrics
+func (s *Scraper) visit(ctx context.Context, keywords string, userAgent string, proxy *proxy) (*Result, Metrics, error) {
+	result := NewResult(keywords, s.opts.Watch)
+	result.Location = s.opts.Location
+	page := 0
+	var failure error // failure of the handlers of the request being visited
+	var met Metrics
+
+	log.Printf("user agent found: %+v", userAgent)
+	c := colly.NewCollector(
+		// the /sorry interstitial of every country is on www.google.com
+		colly.AllowedDomains(s.opts.GoogleDomain, "www."+s.opts.GoogleDomain, "www.google.com"),
+		colly.UserAgent(userAgent),
+	)
+	if err := c.Limit(&colly.LimitRule{DomainGlob: "*", Delay: s.opts.Delay, RandomDelay: s.opts.Jitter}); err != nil {
+		return result, met, err
+	}
+	if proxy != nil {
+		log.Printf("proxy: %s", proxy.name)
+		c.SetProxyFunc(http.ProxyURL(proxy.url))
+		result.Proxy = proxy.name
+	}
+
+	// handler for retrieving SEA and SEO results
+	c.OnResponse(func(r *colly.Response) {
+		if reason := blockedPage(r.Request.URL, r.Body); reason != "" {
+			failure = &Error{Kind: ErrBlocked, Err: errors.New(reason)}
+			if page == 0 {
+				result.Blocked = true
+			}
+			return
+		}
+		if s.opts.OnPage != nil {
+			s.opts.OnPage(result, r.Body, page)
+		}
+		if err := AnalysePage(result, r.Body, s.opts.Parser, page); err != nil {
+			log.Printf("can't parse page %s: %v", r.Request.URL, err)
+		}
+	})
+
+	// on request sent
+	c.OnRequest(func(r *colly.Request) {
+		if failure = s.opts.Budget.Wait(ctx); failure == nil {
+			failure = ctx.Err()
+		}
+		if failure != nil {
+			r.Abort()
+			return
+		}
+		log.Println("Request: ", r.URL.String())
+		userAgent := r.Headers.Get("User-Agent")
+		ua := user_agent.New(userAgent)
+		if ua.Mobile() {
+			if s.opts.Device != "mobile" {
+				failure = &Error{Kind: ErrDeviceMismatch, Err: errors.New("get a user agent mobile but script is not configured for a mobile (device " + s.opts.Device + "). user agent: " + userAgent)}
+			}
+			result.Device = "mobile"
+		} else {
+			result.Device = "desktop"
+			if s.opts.Device == "mobile" {
+				failure = &Error{Kind: ErrDeviceMismatch, Err: errors.New("get a user agent desktop but script is configured for a mobile (device " + s.opts.Device + "). user agent: " + userAgent)}
+			}
+		}
+		if failure != nil {
+			r.Abort()
+			return
+		}
+		if page > 0 {
+			// next pages belong to the result of the first one
+			return
+		}
+		result.URL = r.URL.String()
+		result.UserAgent = userAgent
+		result.Time = time.Now()
+		met = NewMetrics(s.MetricsPrefix(result), s.opts.Sinks)
+		if len(s.opts.Sinks) > 0 {
+			log.Println("metrics sent to graphite (prefix: " + met.prefix + ")")
+		}
+	})
+
+	// google answers too many requests with 429 or 503
+	c.OnError(func(r *colly.Response, err error) {
+		if r.StatusCode == http.StatusTooManyRequests || r.StatusCode == http.StatusServiceUnavailable {
+			failure = &Error{Kind: ErrBlocked, Err: err}
+			if page == 0 {
+				result.Blocked = true
+			}
+		}
+	})
+
+	// after the end of scrapping
+	c.OnScraped(func(r *colly.Response) {
+		log.Println("Finished", r.Request.URL)
+	})
+
+	for page = 0; page < s.opts.Depth; page++ {
+		URL, err := s.searchURL(keywords, page)
+		if err != nil {
+			return result, met, err
+		}
+		organic := len(result.SEO)
+		failure = nil
+		if err = c.Visit(URL); failure != nil {
+			err = failure
+		}
+		if ctx.Err() == nil {
+			// every page counts in the health of the proxy, a canceled
+			// request tells nothing about it
+			s.opts.Proxies.record(proxy, err)
+		}
+		if err != nil {
+			if page == 0 {
+				return result, met, err
+			}
+			log.Printf("page %d of %q failed, results stop at page %d: %v", page+1, keywords, page, err)
+			break
+		}
+		if len(result.SEO) == organic {
+			// no more organic results
+			break
+		}
+	}
+	result.Score(s.opts.Watch)
+	if result.Parser == "" {
+		return result, met, &Error{Kind: ErrParse, Err: errors.New("no parser matched the page")}
+	}
+	return result, met, nil
+}
+
+// resultsPerPage is the number of organic results of a google page, the step
+// of the start parameter
+const resultsPerPage = 10
+
+// searchURL builds the google url requested for keywords, with the domain,
+// language, country and location of the options
+func (s *Scraper) searchURL(keywords string, page int) (string, error) {
+	URL, err := url.Parse("http://www." + s.opts.GoogleDomain)
+	if err != nil {
+		return "", err
+	}
+	URL.Path += "/search"
+	parameters := url.Values{}
+	parameters.Add("q", keywords)
+	if s.opts.Language != "" {
+		parameters.Add("hl", s.opts.Language)
+	}
+	if s.opts.Country != "" {
+		parameters.Add("gl", s.opts.Country)
+	}
+	if s.opts.Location != "" {
+		location, err := uule(s.opts.Location)
+		if err != nil {
+			return "", err
+		}
+		parameters.Add("uule", location)
+	}
+	if page > 0 {
+		parameters.Add("start", strconv.Itoa(page*resultsPerPage))
+	}
+	URL.RawQuery = parameters.Encode()
+	log.Printf("url: %+v", URL.String())
+	return URL.String(), nil
+}
@@ -0,0 +1,208 @@
+// Package serpdiff compares two results of the same search: domains which
+// entered or left the ads, organic position moves, new competitors, waste
+// changes and drops of the watched domains.
+package serpdiff
+
+import (
+	"fmt"
+	"sort"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// Diff is what changed between two results of the same keywords
+type Diff struct {
+	Keywords       string        `json:"keywords"`
+	Device         string        `json:"device"`
+	From           time.Time     `json:"from"`           // time of the old result
+	To             time.Time     `json:"to"`             // time of the new result
+	SEAEntered     []string      `json:"seaEntered"`     // domains which entered the ads of the first page
+	SEALeft        []string      `json:"seaLeft"`        // domains which left the ads of the first page
+	SEOMoves       []Move        `json:"seoMoves"`       // first organic position changes
+	NewCompetitors []string      `json:"newCompetitors"` // domains absent from the old result, watched domains excepted
+	Waste          []WasteChange `json:"waste"`          // waste flag changes of the watched domains
+	Alerts         []Alert       `json:"alerts"`         // drops of the watched domains
+}
+
+// Move of the first organic position of a domain, -1 when it is absent
+type Move struct {
+	Domain string `json:"domain"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+}
+
+// WasteChange of a watched domain
+type WasteChange struct {
+	Domain string `json:"domain"`
+	From   int    `json:"from"`
+	To     int    `json:"to"`
+}
+
+// Alert is raised when a watched domain drops: it left the ads, left the
+// organic results or lost organic positions
+type Alert struct {
+	Time     time.Time `json:"time"`
+	Keywords string    `json:"keywords"`
+	Device   string    `json:"device"`
+	Domain   string    `json:"domain"`
+	Kind     string    `json:"kind"` // sea.left, seo.left or seo.drop
+	From     int       `json:"from"`
+	To       int       `json:"to"`
+	Message  string    `json:"message"`
+}
+
+// SameSearch returns true when two results come from the same search: same
+// keywords, device and location. Other results can't be compared
+func SameSearch(a, b *scraper.Result) bool {
+	return a.Keywords == b.Keywords && a.Device == b.Device && a.Location == b.Location
+}
+
+// Rescore returns a copy of a result scored for the watched domains, so that
+// results scraped with other watched domains are compared with the same ones
+func Rescore(r *scraper.Result, watch []scraper.WatchedDomain) *scraper.Result {
+	scored := scraper.NewResult(r.Keywords, watch)
+	scored.URL = r.URL
+	scored.UserAgent = r.UserAgent
+	scored.Device = r.Device
+	scored.Location = r.Location
+	scored.Parser = r.Parser
+	scored.Time = r.Time
+	scored.SEA = r.SEA
+	scored.SEO = r.SEO
+	scored.Score(watch)
+	return scored
+}
+
+// domainSet returns the domains of search results
+func domainSet(results []scraper.SearchResult) map[string]bool {
+	domains := make(map[string]bool)
+	for _, r := range results {
+		domains[r.Domain] = true
+	}
+	return domains
+}
+
+// firstPositions returns the first position of each domain of search results
+func firstPositions(results []scraper.SearchResult) map[string]int {
+	positions := make(map[string]int)
+	for _, r := range results {
+		if _, ok := positions[r.Domain]; !ok {
+			positions[r.Domain] = r.Position
+		}
+	}
+	return positions
+}
+
+// sortedKeys returns the domains of a set in alphabetical order
+func sortedKeys(set map[string]bool) []string {
+	keys := make([]string, 0, len(set))
+	for k := range set {
+		keys = append(keys, k)
+	}
+	sort.Strings(keys)
+	return keys
+}
+
+// isWatched returns true if domain is a watched domain or one of its siblings
+func isWatched(domain string, watch []scraper.WatchedDomain) bool {
+	for _, w := range watch {
+		if w.IsSibling(domain) {
+			return true
+		}
+	}
+	return false
+}
+
+// Compare compares two results of the same search (see SameSearch) for the
+// watched domains. Blocked and unparsed results have nothing to compare
+func Compare(before, after *scraper.Result, watch []scraper.WatchedDomain) *Diff {
+	before, after = Rescore(before, watch), Rescore(after, watch)
+	d := &Diff{
+		Keywords:       after.Keywords,
+		Device:         after.Device,
+		From:           before.Time,
+		To:             after.Time,
+		SEAEntered:     make([]string, 0),
+		SEALeft:        make([]string, 0),
+		SEOMoves:       make([]Move, 0),
+		NewCompetitors: make([]string, 0),
+		Waste:          make([]WasteChange, 0),
+		Alerts:         make([]Alert, 0),
+	}
+
+	// ads of the first page, the next pages don't always have some
+	oldSEA, newSEA := domainSet(before.FirstPageSEA()), domainSet(after.FirstPageSEA())
+	for _, domain := range sortedKeys(newSEA) {
+		if !oldSEA[domain] {
+			d.SEAEntered = append(d.SEAEntered, domain)
+		}
+	}
+	for _, domain := range sortedKeys(oldSEA) {
+		if !newSEA[domain] {
+			d.SEALeft = append(d.SEALeft, domain)
+		}
+	}
+
+	// organic positions
+	oldSEO, newSEO := firstPositions(before.SEO), firstPositions(after.SEO)
+	domains := make(map[string]bool)
+	for domain := range oldSEO {
+		domains[domain] = true
+	}
+	for domain := range newSEO {
+		domains[domain] = true
+	}
+	for _, domain := range sortedKeys(domains) {
+		from, ok := oldSEO[domain]
+		if !ok {
+			from = -1
+		}
+		to, ok := newSEO[domain]
+		if !ok {
+			to = -1
+		}
+		if from != to {
+			d.SEOMoves = append(d.SEOMoves, Move{Domain: domain, From: from, To: to})
+		}
+	}
+
+	// competitors: domains never seen in the old result
+	seen := domainSet(append(append([]scraper.SearchResult{}, before.SEA...), before.SEO...))
+	current := domainSet(append(append([]scraper.SearchResult{}, after.SEA...), after.SEO...))
+	for _, domain := range sortedKeys(current) {
+		if seen[domain] || isWatched(domain, watch) {
+			continue
+		}
+		d.NewCompetitors = append(d.NewCompetitors, domain)
+	}
+
+	// watched domains
+	for _, w := range watch {
+		if before.Waste[w.Domain] != after.Waste[w.Domain] {
+			d.Waste = append(d.Waste, WasteChange{Domain: w.Domain, From: before.Waste[w.Domain], To: after.Waste[w.Domain]})
+		}
+		alert := func(kind string, from, to int, message string) {
+			d.Alerts = append(d.Alerts, Alert{
+				Time:     after.Time,
+				Keywords: after.Keywords,
+				Device:   after.Device,
+				Domain:   w.Domain,
+				Kind:     kind,
+				From:     from,
+				To:       to,
+				Message:  fmt.Sprintf("%s %s for %q (%s)", w.Domain, message, after.Keywords, after.Device),
+			})
+		}
+		if from, to := before.SEAFirst[w.Domain], after.SEAFirst[w.Domain]; from >= 0 && to < 0 {
+			alert("sea.left", from, to, "left the ads")
+		}
+		switch from, to := before.SEOFirst[w.Domain], after.SEOFirst[w.Domain]; {
+		case from >= 0 && to < 0:
+			alert("seo.left", from, to, "left the organic results")
+		case from >= 0 && to > from:
+			alert("seo.drop", from, to, fmt.Sprintf("dropped from organic position %d to %d", from, to))
+		}
+	}
+	return d
+}
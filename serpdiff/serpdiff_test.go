@@ -0,0 +1,95 @@
+package serpdiff
+
+import (
+	"reflect"
+	"testing"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+const oui = "www.oui.sncf"
+
+var watch = []scraper.WatchedDomain{{Domain: oui, Siblings: []string{"www.sncf.com"}}}
+
+// result builds a desktop result from the domains of its first page ads and
+// organic results
+func result(at time.Time, ads []string, organic []string) *scraper.Result {
+	r := scraper.NewResult("paris lyon", nil)
+	r.Device = "desktop"
+	r.Parser = "v2019"
+	r.Time = at
+	for i, d := range ads {
+		r.SEA = append(r.SEA, scraper.SearchResult{Domain: d, Position: i, Block: "top"})
+	}
+	for i, d := range organic {
+		r.SEO = append(r.SEO, scraper.SearchResult{Domain: d, Position: i})
+	}
+	return r
+}
+
+func TestCompare(t *testing.T) {
+	from, to := time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC), time.Date(2018, 5, 4, 12, 0, 0, 0, time.UTC)
+	before := result(from, []string{oui}, []string{"www.sncf.com", oui, "www.kayak.fr"})
+	after := result(to, []string{"www.trainline.fr", "www.goeuro.fr"}, []string{"www.kayak.fr", "www.sncf.com", "www.blablacar.fr", oui})
+	// ads of the next pages aren't compared
+	after.SEA = append(after.SEA, scraper.SearchResult{Domain: oui, Position: 0, Block: "top", Page: 1})
+
+	got := Compare(before, after, watch)
+	want := &Diff{
+		Keywords:   "paris lyon",
+		Device:     "desktop",
+		From:       from,
+		To:         to,
+		SEAEntered: []string{"www.goeuro.fr", "www.trainline.fr"},
+		SEALeft:    []string{oui},
+		SEOMoves: []Move{
+			{Domain: "www.blablacar.fr", From: -1, To: 2},
+			{Domain: "www.kayak.fr", From: 2, To: 0},
+			{Domain: oui, From: 1, To: 3},
+			{Domain: "www.sncf.com", From: 0, To: 1},
+		},
+		NewCompetitors: []string{"www.blablacar.fr", "www.goeuro.fr", "www.trainline.fr"},
+		Waste:          []WasteChange{{Domain: oui, From: 1, To: 0}},
+		Alerts: []Alert{
+			{Time: to, Keywords: "paris lyon", Device: "desktop", Domain: oui, Kind: "sea.left", From: 0, To: -1,
+				Message: `www.oui.sncf left the ads for "paris lyon" (desktop)`},
+			{Time: to, Keywords: "paris lyon", Device: "desktop", Domain: oui, Kind: "seo.drop", From: 1, To: 3,
+				Message: `www.oui.sncf dropped from organic position 1 to 3 for "paris lyon" (desktop)`},
+		},
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("Compare() = %+v, want %+v", got, want)
+	}
+}
+
+func TestCompareUnchanged(t *testing.T) {
+	r := result(time.Date(2018, 5, 4, 10, 0, 0, 0, time.UTC), []string{oui}, []string{oui})
+	d := Compare(r, r, watch)
+	if len(d.SEAEntered)+len(d.SEALeft)+len(d.SEOMoves)+len(d.NewCompetitors)+len(d.Waste)+len(d.Alerts) > 0 {
+		t.Errorf("Compare() of a result with itself = %+v, want no change", d)
+	}
+}
+
+func TestSameSearch(t *testing.T) {
+	r := result(time.Time{}, nil, nil)
+	tests := []struct {
+		name   string
+		change func(*scraper.Result)
+		want   bool
+	}{
+		{"same", func(*scraper.Result) {}, true},
+		{"other keywords", func(o *scraper.Result) { o.Keywords = "paris marseille" }, false},
+		{"other device", func(o *scraper.Result) { o.Device = "mobile" }, false},
+		{"other location", func(o *scraper.Result) { o.Location = "Lyon,Auvergne-Rhone-Alpes,France" }, false},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			other := *r
+			test.change(&other)
+			if got := SameSearch(r, &other); got != test.want {
+				t.Errorf("SameSearch() = %v, want %v", got, test.want)
+			}
+		})
+	}
+}
@@ -0,0 +1,358 @@
+package main
+
+import (
+	"context"
+	"crypto/rand"
+	"encoding/hex"
+	"encoding/json"
+	"flag"
+	"log"
+	"net/http"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// scrapeRequest is the body of POST /scrapes. Empty fields take the values
+// of the config
+type scrapeRequest struct {
+	Keywords     string `json:"keywords"`
+	Device       string `json:"device"`
+	GoogleDomain string `json:"googleDomain"`
+	Language     string `json:"hl"`
+	Country      string `json:"gl"`
+	Location     string `json:"location"`
+}
+
+// scrapeStatus of a submitted scrape
+type scrapeStatus struct {
+	ID        string        `json:"id"`
+	Status    string        `json:"status"` // queued, running, done or failed
+	Error     string        `json:"error,omitempty"`
+	Request   scrapeRequest `json:"request"`
+	Submitted time.Time     `json:"submitted"`
+	Finished  *time.Time    `json:"finished,omitempty"`
+
+	result *scraper.Result
+}
+
+// api serves the scrapes over http. Scrapes are queued and run one at a time
+// so that callers can't overload google
+type api struct {
+	session *session // scraper of the scrapes, with the history and the rules
+	queue   chan *scrapeStatus
+	keep    int // number of finished scrapes kept in memory
+
+	mutex   sync.Mutex
+	closed  bool // queue closed, no more scrapes are accepted
+	scrapes map[string]*scrapeStatus
+	order   []string // ids by submission
+}
+
+// newID returns a random scrape id
+func newID() string {
+	b := make([]byte, 8)
+	rand.Read(b)
+	return hex.EncodeToString(b)
+}
+
+// writeJSON writes v as the json response
+func writeJSON(w http.ResponseWriter, status int, v interface{}) {
+	w.Header().Set("Content-Type", "application/json")
+	w.WriteHeader(status)
+	if err := json.NewEncoder(w).Encode(v); err != nil {
+		log.Printf("can't write response: %v", err)
+	}
+}
+
+// writeError writes an error as the json response
+func writeError(w http.ResponseWriter, status int, message string) {
+	writeJSON(w, status, map[string]string{"error": message})
+}
+
+// handleScrapes submits a scrape (POST /scrapes), returns its status
+// (GET /scrapes/{id}) or its result (GET /scrapes/{id}/result)
+func (a *api) handleScrapes(w http.ResponseWriter, r *http.Request) {
+	path := strings.Trim(strings.TrimPrefix(r.URL.Path, "/scrapes"), "/")
+	if path == "" {
+		if r.Method != http.MethodPost {
+			writeError(w, http.StatusMethodNotAllowed, "use POST to submit a scrape")
+			return
+		}
+		a.submit(w, r)
+		return
+	}
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET to poll a scrape")
+		return
+	}
+
+	parts := strings.Split(path, "/")
+	a.mutex.Lock()
+	s, ok := a.scrapes[parts[0]]
+	var status scrapeStatus
+	if ok {
+		status = *s
+	}
+	a.mutex.Unlock()
+	switch {
+	case !ok:
+		writeError(w, http.StatusNotFound, "unknown scrape "+parts[0])
+	case len(parts) == 1:
+		writeJSON(w, http.StatusOK, status)
+	case len(parts) == 2 && parts[1] == "result":
+		if status.result == nil {
+			writeError(w, http.StatusNotFound, "scrape "+status.ID+" is "+status.Status)
+			return
+		}
+		writeJSON(w, http.StatusOK, status.result)
+	default:
+		writeError(w, http.StatusNotFound, "unknown path "+r.URL.Path)
+	}
+}
+
+// submit queues a scrape
+func (a *api) submit(w http.ResponseWriter, r *http.Request) {
+	req := scrapeRequest{}
+	if err := json.NewDecoder(r.Body).Decode(&req); err != nil {
+		writeError(w, http.StatusBadRequest, "invalid scrape request: "+err.Error())
+		return
+	}
+	req.Keywords = strings.TrimSpace(req.Keywords)
+	if req.Keywords == "" {
+		writeError(w, http.StatusBadRequest, "keywords are required")
+		return
+	}
+	if req.Device != "" && req.Device != "desktop" && req.Device != "mobile" {
+		writeError(w, http.StatusBadRequest, "unknown device "+req.Device+" (available: desktop, mobile)")
+		return
+	}
+	req.GoogleDomain = strings.TrimPrefix(strings.ToLower(strings.TrimSpace(req.GoogleDomain)), "www.")
+	if req.GoogleDomain != "" {
+		if err := scraper.CheckGoogleDomain(req.GoogleDomain); err != nil {
+			writeError(w, http.StatusBadRequest, err.Error())
+			return
+		}
+	}
+
+	s := &scrapeStatus{ID: newID(), Status: "queued", Request: req, Submitted: time.Now()}
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	if a.closed {
+		writeError(w, http.StatusServiceUnavailable, "shutting down")
+		return
+	}
+	select {
+	case a.queue <- s:
+	default:
+		writeError(w, http.StatusServiceUnavailable, "too many scrapes in progress, retry later")
+		return
+	}
+	a.scrapes[s.ID] = s
+	a.order = append(a.order, s.ID)
+	a.forget()
+	status := *s
+
+	w.Header().Set("Location", "/scrapes/"+s.ID)
+	writeJSON(w, http.StatusAccepted, status)
+}
+
+// close closes the queue, submits are then rejected. Submits send on the
+// queue with the mutex held so that none sends on a closed queue, even once
+// the shutdown timed out
+func (a *api) close() {
+	a.mutex.Lock()
+	defer a.mutex.Unlock()
+	a.closed = true
+	close(a.queue)
+}
+
+// forget removes the oldest finished scrapes beyond the kept ones. The mutex
+// must be held
+func (a *api) forget() {
+	for len(a.order) > a.keep {
+		oldest := a.scrapes[a.order[0]]
+		if oldest.Status == "queued" || oldest.Status == "running" {
+			return
+		}
+		delete(a.scrapes, oldest.ID)
+		a.order = a.order[1:]
+	}
+}
+
+// handleResults lists the recent results, most recent first
+// (GET /results?limit=20&keywords=...&device=...)
+func (a *api) handleResults(w http.ResponseWriter, r *http.Request) {
+	if r.Method != http.MethodGet {
+		writeError(w, http.StatusMethodNotAllowed, "use GET to list results")
+		return
+	}
+	limit := 20
+	if l := r.URL.Query().Get("limit"); l != "" {
+		var err error
+		if limit, err = strconv.Atoi(l); err != nil || limit <= 0 {
+			writeError(w, http.StatusBadRequest, "invalid limit "+l)
+			return
+		}
+	}
+	keywords := r.URL.Query().Get("keywords")
+	device := r.URL.Query().Get("device")
+
+	results := make([]*scraper.Result, 0)
+	a.mutex.Lock()
+	for i := len(a.order) - 1; i >= 0 && len(results) < limit; i-- {
+		result := a.scrapes[a.order[i]].result
+		if result == nil || keywords != "" && result.Keywords != keywords || device != "" && result.Device != device {
+			continue
+		}
+		results = append(results, result)
+	}
+	a.mutex.Unlock()
+	writeJSON(w, http.StatusOK, results)
+}
+
+// work runs the queued scrapes until the queue is closed, scrapes are stopped
+// with ctx
+func (a *api) work(ctx context.Context) {
+	for s := range a.queue {
+		a.mutex.Lock()
+		s.Status = "running"
+		req := s.Request
+		a.mutex.Unlock()
+
+		result, err := a.session.runScrape(ctx, req.Keywords, func(opts *scraper.Options) {
+			if req.Device != "" {
+				opts.Device = req.Device
+			}
+			if req.GoogleDomain != "" {
+				opts.GoogleDomain = req.GoogleDomain
+			}
+			if req.Language != "" {
+				opts.Language = req.Language
+			}
+			if req.Country != "" {
+				opts.Country = req.Country
+			}
+			if req.Location != "" {
+				opts.Location = req.Location
+			}
+		})
+
+		finished := time.Now()
+		a.mutex.Lock()
+		s.Finished = &finished
+		if err != nil {
+			log.Printf("scrape %s of %q failed: %v", s.ID, req.Keywords, err)
+			s.Status = "failed"
+			s.Error = err.Error()
+		} else {
+			s.Status = "done"
+			s.result = result
+		}
+		a.mutex.Unlock()
+	}
+}
+
+// serve runs the http api until SIGTERM or SIGINT and returns the exit code
+// of the process
+func serve(args []string) int {
+	fs := flag.NewFlagSet("serve", flag.ExitOnError)
+	listen := fs.String("listen", ":8080", "address of the http api")
+	maxQueue := fs.Int("max-queue", 10, "maximum number of scrapes waiting to run, more are rejected")
+	keep := fs.Int("keep", 100, "number of scrapes kept in memory")
+	cfg, err := parseConfig(fs, args)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	if _, err := scraper.SelectParsers(cfg.Parser, ""); err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	sinks, err := openSinks(cfg)
+	if err != nil {
+		log.Printf("can't open metrics sinks: %v", err)
+		return exitCode(err)
+	}
+	db, err := openHistory(cfg)
+	if err != nil {
+		log.Printf("can't open history: %v", err)
+		return exitFailure
+	}
+	rules, err := openRules(cfg, db)
+	if err != nil {
+		log.Printf("can't load rules: %v", err)
+		return exitUsage
+	}
+	// one scraper for every scrape, sharing the budget, proxies and sinks
+	sess, err := newSession(cfg, sinks, nil, true)
+	if err != nil {
+		log.Printf("invalid config: %v", err)
+		return exitUsage
+	}
+	sess.store = db
+	sess.rules = rules
+
+	a := &api{
+		session: sess,
+		queue:   make(chan *scrapeStatus, *maxQueue),
+		keep:    *keep,
+		scrapes: make(map[string]*scrapeStatus),
+		order:   make([]string, 0),
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan bool)
+	go func() {
+		a.work(ctx)
+		close(done)
+	}()
+
+	mux := http.NewServeMux()
+	mux.HandleFunc("/scrapes", a.handleScrapes)
+	mux.HandleFunc("/scrapes/", a.handleScrapes)
+	mux.HandleFunc("/results", a.handleResults)
+	server := &http.Server{Addr: *listen, Handler: mux}
+
+	// graceful shutdown: ListenAndServe returns as soon as Shutdown is called,
+	// the queue is closed once Shutdown returned, when no submit can send on it
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, syscall.SIGTERM, os.Interrupt)
+	stopped := make(chan bool)
+	go func() {
+		defer close(stopped)
+		sig := <-signals
+		log.Printf("%v received, finishing in-flight request", sig)
+		cancel()
+		shutdown, cancelShutdown := context.WithTimeout(context.Background(), 30*time.Second)
+		defer cancelShutdown()
+		if err := server.Shutdown(shutdown); err != nil {
+			log.Printf("http api shutdown: %v", err)
+		}
+	}()
+
+	log.Printf("http api listening on %s", *listen)
+	if err := server.ListenAndServe(); err != http.ErrServerClosed {
+		log.Printf("http api failed: %v", err)
+		return exitFailure
+	}
+	<-stopped
+	a.close()
+	<-done
+	sess.close()
+
+	if err := closeSinks(sinks); err != nil {
+		log.Printf("metrics: %v", err)
+	}
+	if db != nil {
+		db.Close()
+	}
+	logProxies(sess.proxies)
+	log.Println("http api stopped")
+	return 0
+}
@@ -0,0 +1,147 @@
+package main
+
+import (
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"sync"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+)
+
+// session scraps keywords with the scraper of a config, built once per run
+// of a command. Up to cfg.Workers keywords are scraped at the same time, each
+// result is then written, kept and checked
+type session struct {
+	cfg     *Config
+	scraper *scraper.Scraper
+	store   *store             // history of the results, nil when disabled
+	rules   *ruleEngine        // alerting rules, nil when disabled
+	out     *output            // where results are written, nil when disabled
+	workers chan bool          // one slot per keywords being scraped
+	budget  *scraper.Budget    // requests per minute of the session, nil for no limit
+	proxies *scraper.ProxyPool // proxies of the requests, nil for direct requests
+
+	// results are finished one at a time: the output isn't safe for
+	// concurrent use
+	mutex sync.Mutex
+}
+
+// scrape of keywords started by a worker
+type scrape struct {
+	keywords string
+	result   *scraper.Result
+	err      error
+	done     chan bool // closed when the scrape is finished
+}
+
+// newSession builds the scraper of a config, with its budget and proxies. In
+// batch mode, the metrics of each keywords have their own prefix. Results are
+// written to out if not nil. The session is closed once its scrapes are finished
+func newSession(cfg *Config, sinks []scraper.MetricSink, out *output, batch bool) (*session, error) {
+	s := &session{cfg: cfg, out: out, workers: make(chan bool, cfg.Workers)}
+	opts := scraper.Options{
+		Device:          cfg.Device,
+		UserAgent:       os.Getenv("USER_AGENT"),
+		Parser:          cfg.Parser,
+		GoogleDomain:    cfg.GoogleDomain,
+		Language:        cfg.Language,
+		Country:         cfg.Country,
+		Location:        cfg.Location,
+		Depth:           cfg.Depth,
+		Watch:           cfg.Watch,
+		Delay:           time.Duration(cfg.Delay),
+		Jitter:          time.Duration(cfg.Jitter),
+		Retries:         cfg.Retries,
+		Backoff:         time.Duration(cfg.Backoff),
+		Sinks:           sinks,
+		Prefix:          cfg.Prefix,
+		KeywordsMetrics: batch,
+	}
+	if cfg.SaveDir != "" {
+		opts.OnPage = func(result *scraper.Result, body []byte, page int) {
+			if err := saveSnapshot(cfg.SaveDir, body, result, s.scraper.MetricsPrefix(result), page); err != nil {
+				log.Printf("can't save page %s: %v", result.URL, err)
+			}
+		}
+	}
+	var err error
+	if s.proxies, err = openProxies(cfg); err != nil {
+		return nil, err
+	}
+	opts.Proxies = s.proxies
+	s.budget = scraper.NewBudget(cfg.RPM)
+	opts.Budget = s.budget
+	if s.scraper, err = scraper.New(opts); err != nil {
+		s.budget.Stop()
+		return nil, err
+	}
+	return s, nil
+}
+
+// close stops the budget of the session, its scrapes must be finished
+func (s *session) close() {
+	s.budget.Stop()
+}
+
+// start scrapes keywords as soon as a worker is free. The scrape is finished
+// when its done channel is closed
+func (s *session) start(ctx context.Context, keywords string) *scrape {
+	sc := &scrape{keywords: keywords, done: make(chan bool)}
+	s.workers <- true
+	go func() {
+		defer func() {
+			<-s.workers
+			close(sc.done)
+		}()
+		sc.result, sc.err = s.visit(ctx, s.scraper, keywords)
+	}()
+	return sc
+}
+
+// visit scrapes keywords with sc, then writes, keeps and checks the result. A
+// blocked or unparsed result is written and kept but not checked, a result
+// whose first page failed otherwise is returned as is. The retries of a
+// blocked scrape stop with ctx
+func (s *session) visit(ctx context.Context, sc *scraper.Scraper, keywords string) (*scraper.Result, error) {
+	result, err := sc.Scrape(ctx, keywords)
+	if err != nil && scraper.KindOf(err) != scraper.ErrParse && !result.Blocked {
+		return result, err
+	}
+
+	s.mutex.Lock()
+	defer s.mutex.Unlock()
+	if s.out != nil {
+		if err := s.out.Write(result); err != nil {
+			log.Printf("can't write result of %q: %v", result.Keywords, err)
+		}
+	}
+	if s.store != nil {
+		if err := s.store.Save(result); err != nil {
+			log.Printf("can't save result of %q in history: %v", result.Keywords, err)
+		}
+	}
+	if result.Parsed() {
+		s.rules.Check(result)
+	}
+	return result, err
+}
+
+// runScrape scrapes keywords until ctx is done with the scraper of the
+// session changed by change (device, location... of a job), which shares its
+// budget, proxies and sinks. A panic of the handlers is returned as an error
+// so that a long running process survives it
+func (s *session) runScrape(ctx context.Context, keywords string, change func(*scraper.Options)) (result *scraper.Result, err error) {
+	defer func() {
+		if r := recover(); r != nil {
+			err = fmt.Errorf("%v", r)
+		}
+	}()
+	sc, err := s.scraper.With(change)
+	if err != nil {
+		return nil, err
+	}
+	return s.visit(ctx, sc, keywords)
+}
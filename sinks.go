@@ -0,0 +1,391 @@
+package main
+
+import (
+	"bufio"
+	"encoding/csv"
+	"errors"
+	"fmt"
+	"io"
+	"log"
+	"math"
+	"net"
+	"os"
+	"strconv"
+	"strings"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+	"github.com/marpaia/graphite-golang"
+)
+
+// sinkConfig defines a metrics sink: its type (graphite, csv, influx or
+// statsd) and its address (host:port, file, udp://host:port)
+type sinkConfig struct {
+	Type    string `json:"type"`
+	Address string `json:"address,omitempty"`
+}
+
+// defaultSinks are used when neither the config file nor the flags define sinks
+var defaultSinks = []sinkConfig{{Type: "graphite"}, {Type: "csv"}}
+
+// sinkTypes are the types accepted by -sink
+var sinkTypes = []string{"graphite", "csv", "influx", "statsd"}
+
+// graphiteConfig defines the graphite endpoint
+type graphiteConfig struct {
+	Host     string `json:"host"`
+	Port     int    `json:"port"`
+	Protocol string `json:"protocol"` // tcp or udp
+	Spool    string `json:"spool"`    // file where metrics are kept while graphite is unavailable
+}
+
+// defaultGraphite is the graphite relay of the team
+var defaultGraphite = graphiteConfig{Host: "10.98.208.116", Port: 52630, Protocol: "tcp", Spool: "graphite.spool"}
+
+// openSinks creates the sinks of the config
+func openSinks(cfg *Config) ([]scraper.MetricSink, error) {
+	sinks := make([]scraper.MetricSink, 0, len(cfg.Sinks))
+	for _, sc := range cfg.Sinks {
+		var sink scraper.MetricSink
+		var err error
+		switch sc.Type {
+		case "graphite":
+			sink, err = newGraphiteSink(cfg.Graphite, sc.Address)
+		case "csv":
+			sink, err = newCSVSink(sc.Address)
+		case "influx":
+			sink, err = newInfluxSink(sc.Address)
+		case "statsd":
+			sink, err = newStatsdSink(sc.Address)
+		default:
+			err = fmt.Errorf("unknown sink type %q (available: %s)", sc.Type, strings.Join(sinkTypes, ", "))
+		}
+		if err != nil {
+			closeSinks(sinks)
+			return nil, &scraper.Error{Kind: scraper.ErrSink, Err: err}
+		}
+		sinks = append(sinks, sink)
+	}
+	return sinks, nil
+}
+
+// closeSinks flushes and closes sinks. The returned error reports the
+// failures of all the sinks
+func closeSinks(sinks []scraper.MetricSink) error {
+	failures := make([]string, 0)
+	for _, sink := range sinks {
+		if err := sink.Flush(); err != nil {
+			failures = append(failures, err.Error())
+		}
+		if err := sink.Close(); err != nil {
+			failures = append(failures, err.Error())
+		}
+	}
+	if len(failures) > 0 {
+		return errors.New(strings.Join(failures, "; "))
+	}
+	return nil
+}
+
+// sinkFlag parses a repeatable -sink flag. The first occurrence replaces the
+// sinks of the config file
+type sinkFlag struct {
+	cfg *Config
+	set bool
+}
+
+func (f *sinkFlag) String() string {
+	if f == nil || f.cfg == nil {
+		return ""
+	}
+	sinks := make([]string, 0, len(f.cfg.Sinks))
+	for _, sc := range f.cfg.Sinks {
+		sinks = append(sinks, sc.Type)
+	}
+	return strings.Join(sinks, ",")
+}
+
+func (f *sinkFlag) Set(value string) error {
+	if !f.set {
+		f.cfg.Sinks = nil
+		f.set = true
+	}
+	parts := strings.SplitN(value, "=", 2)
+	sc := sinkConfig{Type: strings.TrimSpace(parts[0])}
+	if sc.Type == "" {
+		return errors.New("empty sink type")
+	}
+	if len(parts) == 2 {
+		sc.Address = strings.TrimSpace(parts[1])
+	}
+	f.cfg.Sinks = append(f.cfg.Sinks, sc)
+	return nil
+}
+
+// splitHostPort splits a host:port address, with default values
+func splitHostPort(address string, defaultHost string, defaultPort int) (string, int, error) {
+	if address == "" {
+		return defaultHost, defaultPort, nil
+	}
+	host, port, err := net.SplitHostPort(address)
+	if err != nil {
+		return "", 0, err
+	}
+	p, err := strconv.Atoi(port)
+	if err != nil {
+		return "", 0, fmt.Errorf("invalid port in %s: %v", address, err)
+	}
+	return host, p, nil
+}
+
+// numeric converts a metric value to a float, false when it is not a number
+func numeric(value interface{}) (float64, bool) {
+	f, err := strconv.ParseFloat(fmt.Sprintf("%v", value), 64)
+	if err != nil || math.IsNaN(f) || math.IsInf(f, 0) {
+		return 0, false
+	}
+	return f, true
+}
+
+// graphiteSink sends metrics to graphite (only logged when MODE!=prod).
+// While graphite is unavailable, metrics are counted and appended to a spool
+// file, replayed on the next successful connection
+type graphiteSink struct {
+	graphite  *graphite.Graphite
+	spool     string
+	connected bool
+	sent      int // metrics sent to graphite
+	failed    int // metrics spooled
+}
+
+func newGraphiteSink(cfg graphiteConfig, address string) (*graphiteSink, error) {
+	host, port, err := splitHostPort(address, cfg.Host, cfg.Port)
+	if err != nil {
+		return nil, err
+	}
+	if os.Getenv("MODE") != "prod" {
+		g, err := graphite.GraphiteFactory("nop", host, port, "")
+		if err != nil {
+			return nil, err
+		}
+		return &graphiteSink{graphite: g, connected: true}, nil
+	}
+	if cfg.Protocol != "tcp" && cfg.Protocol != "udp" {
+		return nil, fmt.Errorf("unknown graphite protocol %q (available: tcp, udp)", cfg.Protocol)
+	}
+
+	s := &graphiteSink{
+		graphite: &graphite.Graphite{Host: host, Port: port, Protocol: cfg.Protocol},
+		spool:    cfg.Spool,
+	}
+	if err := s.graphite.Connect(); err != nil {
+		log.Printf("can't connect to graphite %s:%d: %v, metrics are spooled to %s", host, port, err, s.spool)
+		return s, nil
+	}
+	s.connected = true
+	if err := s.replaySpool(); err != nil {
+		log.Printf("can't replay graphite spool %s: %v", s.spool, err)
+	}
+	return s, nil
+}
+
+func (s *graphiteSink) Send(metric string, value interface{}, t time.Time) error {
+	m := graphite.NewMetric(metric, fmt.Sprintf("%v", value), t.Unix())
+	if s.connected {
+		err := s.graphite.SendMetric(m)
+		if err != nil {
+			// connection may have been closed by the relay, retry once
+			if s.graphite.Connect() == nil {
+				err = s.graphite.SendMetric(m)
+			}
+		}
+		if err == nil {
+			s.sent++
+			return nil
+		}
+		log.Printf("can't send metrics to graphite %s:%d: %v, metrics are spooled to %s", s.graphite.Host, s.graphite.Port, err, s.spool)
+		s.connected = false
+	}
+	s.failed++
+	return s.spoolMetric(m)
+}
+
+// spoolMetric appends a metric to the spool file in the graphite plaintext format
+func (s *graphiteSink) spoolMetric(m graphite.Metric) error {
+	if s.spool == "" {
+		return errors.New("graphite unavailable and no spool file: metric " + m.Name + " lost")
+	}
+	file, err := os.OpenFile(s.spool, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return err
+	}
+	defer file.Close()
+	_, err = fmt.Fprintf(file, "%s %s %d\n", m.Name, m.Value, m.Timestamp)
+	return err
+}
+
+// replaySpool sends the metrics spooled by previous runs, then removes the spool
+func (s *graphiteSink) replaySpool() error {
+	if s.spool == "" {
+		return nil
+	}
+	file, err := os.Open(s.spool)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	metrics := make([]graphite.Metric, 0)
+	scanner := bufio.NewScanner(file)
+	for scanner.Scan() {
+		fields := strings.Fields(scanner.Text())
+		if len(fields) != 3 {
+			continue
+		}
+		timestamp, err := strconv.ParseInt(fields[2], 10, 64)
+		if err != nil {
+			continue
+		}
+		metrics = append(metrics, graphite.NewMetric(fields[0], fields[1], timestamp))
+	}
+	file.Close()
+	if err := scanner.Err(); err != nil {
+		return err
+	}
+	if err := s.graphite.SendMetrics(metrics); err != nil {
+		return err
+	}
+	log.Printf("%d spooled metrics sent to graphite", len(metrics))
+	return os.Remove(s.spool)
+}
+
+func (s *graphiteSink) Flush() error {
+	return nil
+}
+
+// Close disconnects from graphite and reports the spooled metrics
+func (s *graphiteSink) Close() error {
+	if !s.graphite.IsNop() && s.connected {
+		s.graphite.Disconnect()
+	}
+	if s.failed > 0 {
+		return fmt.Errorf("graphite: %d metrics sent, %d failed and spooled to %s", s.sent, s.failed, s.spool)
+	}
+	return nil
+}
+
+// csvSink appends metrics to a csv file: date, timestamp, metric, value
+type csvSink struct {
+	file *os.File
+	csv  *csv.Writer
+}
+
+func newCSVSink(path string) (*csvSink, error) {
+	if path == "" {
+		path = "result.csv"
+	}
+	file, err := os.OpenFile(path, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0600)
+	if err != nil {
+		return nil, err
+	}
+	return &csvSink{file: file, csv: csv.NewWriter(file)}, nil
+}
+
+func (s *csvSink) Send(metric string, value interface{}, t time.Time) error {
+	return s.csv.Write([]string{t.Format("2006-01-02 15:04:05"), fmt.Sprintf("%d", (t.Unix())), metric, fmt.Sprintf("%v", value)})
+}
+
+func (s *csvSink) Flush() error {
+	s.csv.Flush()
+	return s.csv.Error()
+}
+
+func (s *csvSink) Close() error {
+	return s.file.Close()
+}
+
+// influxSink writes metrics in the influxdb line protocol, to a file or to
+// an udp listener (udp://host:port). Each metric is a measurement with a
+// float "value" field, non numeric values are skipped
+type influxSink struct {
+	writer io.WriteCloser
+}
+
+func newInfluxSink(address string) (*influxSink, error) {
+	if address == "" {
+		address = "metrics.lp"
+	}
+	var writer io.WriteCloser
+	var err error
+	if strings.HasPrefix(address, "udp://") {
+		writer, err = net.Dial("udp", strings.TrimPrefix(address, "udp://"))
+	} else {
+		writer, err = os.OpenFile(address, os.O_CREATE|os.O_APPEND|os.O_WRONLY, 0644)
+	}
+	if err != nil {
+		return nil, err
+	}
+	return &influxSink{writer: writer}, nil
+}
+
+// influxEscaper escapes measurement names of the line protocol
+var influxEscaper = strings.NewReplacer(",", "\\,", " ", "\\ ")
+
+func (s *influxSink) Send(metric string, value interface{}, t time.Time) error {
+	f, ok := numeric(value)
+	if !ok {
+		return nil
+	}
+	_, err := fmt.Fprintf(s.writer, "%s value=%s %d\n", influxEscaper.Replace(metric), strconv.FormatFloat(f, 'f', -1, 64), t.UnixNano())
+	return err
+}
+
+func (s *influxSink) Flush() error {
+	return nil
+}
+
+func (s *influxSink) Close() error {
+	return s.writer.Close()
+}
+
+// statsdSink sends metrics as statsd gauges over udp. Timestamps are set by
+// statsd, non numeric values are skipped
+type statsdSink struct {
+	conn net.Conn
+}
+
+func newStatsdSink(address string) (*statsdSink, error) {
+	if address == "" {
+		address = "localhost:8125"
+	}
+	conn, err := net.Dial("udp", address)
+	if err != nil {
+		return nil, err
+	}
+	return &statsdSink{conn: conn}, nil
+}
+
+func (s *statsdSink) Send(metric string, value interface{}, t time.Time) error {
+	f, ok := numeric(value)
+	if !ok {
+		return nil
+	}
+	gauge := strconv.FormatFloat(f, 'f', -1, 64)
+	var err error
+	if f < 0 {
+		// a signed gauge is a delta for statsd: reset it before
+		_, err = fmt.Fprintf(s.conn, "%s:0|g\n%s:%s|g\n", metric, metric, gauge)
+	} else {
+		_, err = fmt.Fprintf(s.conn, "%s:%s|g\n", metric, gauge)
+	}
+	return err
+}
+
+func (s *statsdSink) Flush() error {
+	return nil
+}
+
+func (s *statsdSink) Close() error {
+	return s.conn.Close()
+}
@@ -0,0 +1,232 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"errors"
+	"fmt"
+	"strconv"
+	"time"
+
+	"github.com/garnaud/hackathon-2018/scraper"
+	bolt "go.etcd.io/bbolt"
+)
+
+// store keeps the history of the results in an embedded bbolt database
+type store struct {
+	db *bolt.DB
+}
+
+var (
+	metaBucket     = []byte("meta")     // schema version
+	resultsBucket  = []byte("results")  // results json by time key
+	keywordsBucket = []byte("keywords") // index: keywords \x00 time key -> nil
+	versionKey     = []byte("version")
+)
+
+// migrations upgrade the schema of the database, migrations[i] upgrades it
+// from version i to version i+1. Append new migrations, never edit old ones
+var migrations = []func(tx *bolt.Tx) error{
+	// 1: results by time
+	func(tx *bolt.Tx) error {
+		_, err := tx.CreateBucketIfNotExists(resultsBucket)
+		return err
+	},
+	// 2: index of the results by keywords
+	func(tx *bolt.Tx) error {
+		index, err := tx.CreateBucketIfNotExists(keywordsBucket)
+		if err != nil {
+			return err
+		}
+		return tx.Bucket(resultsBucket).ForEach(func(k, v []byte) error {
+			result := scraper.Result{}
+			if err := json.Unmarshal(v, &result); err != nil {
+				return err
+			}
+			return index.Put(keywordsKey(result.Keywords, k), nil)
+		})
+	},
+}
+
+// openStore opens (or creates) the database and migrates its schema
+func openStore(path string) (*store, error) {
+	db, err := bolt.Open(path, 0600, &bolt.Options{Timeout: 5 * time.Second})
+	if err != nil {
+		return nil, fmt.Errorf("can't open history %s: %v", path, err)
+	}
+	s := &store{db: db}
+	if err := s.migrate(); err != nil {
+		db.Close()
+		return nil, fmt.Errorf("can't migrate history %s: %v", path, err)
+	}
+	return s, nil
+}
+
+// migrate runs the migrations newer than the schema version, each one in
+// its own transaction
+func (s *store) migrate() error {
+	for {
+		done := false
+		err := s.db.Update(func(tx *bolt.Tx) error {
+			meta, err := tx.CreateBucketIfNotExists(metaBucket)
+			if err != nil {
+				return err
+			}
+			version := 0
+			if v := meta.Get(versionKey); v != nil {
+				if version, err = strconv.Atoi(string(v)); err != nil {
+					return err
+				}
+			}
+			if version > len(migrations) {
+				return fmt.Errorf("schema version %d is newer than this binary (%d)", version, len(migrations))
+			}
+			if version == len(migrations) {
+				done = true
+				return nil
+			}
+			if err := migrations[version](tx); err != nil {
+				return fmt.Errorf("migration %d: %v", version+1, err)
+			}
+			return meta.Put(versionKey, []byte(strconv.Itoa(version+1)))
+		})
+		if err != nil || done {
+			return err
+		}
+	}
+}
+
+// openHistory opens the database of the config, nil when there is none
+func openHistory(cfg *Config) (*store, error) {
+	if cfg.DB == "" {
+		return nil, nil
+	}
+	return openStore(cfg.DB)
+}
+
+// Close the database
+func (s *store) Close() error {
+	return s.db.Close()
+}
+
+// timeKey orders the results by time, the keywords and the device make it unique
+func timeKey(result *scraper.Result) []byte {
+	return []byte(result.Time.UTC().Format("20060102T150405.000000000") + "|" + result.Device + "|" + result.Keywords)
+}
+
+// timePrefix is the start of the keys of the results at t
+func timePrefix(t time.Time) []byte {
+	return []byte(t.UTC().Format("20060102T150405.000000000"))
+}
+
+// keywordsKey is the key of a result in the keywords index
+func keywordsKey(keywords string, key []byte) []byte {
+	return append([]byte(keywords+"\x00"), key...)
+}
+
+// Save a result
+func (s *store) Save(result *scraper.Result) error {
+	value, err := json.Marshal(result)
+	if err != nil {
+		return err
+	}
+	key := timeKey(result)
+	return s.db.Update(func(tx *bolt.Tx) error {
+		if err := tx.Bucket(resultsBucket).Put(key, value); err != nil {
+			return err
+		}
+		return tx.Bucket(keywordsBucket).Put(keywordsKey(result.Keywords, key), nil)
+	})
+}
+
+// historyFilter selects results of the history. Zero values match everything
+type historyFilter struct {
+	Keywords string
+	Device   string
+	Domain   string    // domain present (or absent) in the results
+	In       string    // where the domain is looked for: sea, seo or any
+	Absent   bool      // select results where the domain is absent
+	Since    time.Time // inclusive
+	Until    time.Time // exclusive
+	Limit    int
+}
+
+// match returns true if a result matches the filter, except for keywords and time
+func (f historyFilter) match(result *scraper.Result) bool {
+	if f.Device != "" && result.Device != f.Device {
+		return false
+	}
+	if f.Domain == "" {
+		return true
+	}
+	if !result.Parsed() {
+		// a blocked or unparsed result tells nothing about the domain
+		return false
+	}
+	found := false
+	if f.In != "seo" {
+		for _, sea := range result.FirstPageSEA() {
+			found = found || sea.Domain == f.Domain
+		}
+	}
+	if f.In != "sea" {
+		for _, seo := range result.SEO {
+			found = found || seo.Domain == f.Domain
+		}
+	}
+	return found != f.Absent
+}
+
+// Query returns the results matching the filter, ordered by time
+func (s *store) Query(f historyFilter) ([]*scraper.Result, error) {
+	results := make([]*scraper.Result, 0)
+	err := s.db.View(func(tx *bolt.Tx) error {
+		// keys of the results between since and until
+		var next func() []byte
+		if f.Keywords != "" {
+			prefix := keywordsKey(f.Keywords, nil)
+			c := tx.Bucket(keywordsBucket).Cursor()
+			k, _ := c.Seek(keywordsKey(f.Keywords, timePrefix(f.Since)))
+			next = func() []byte {
+				if k == nil || !bytes.HasPrefix(k, prefix) {
+					return nil
+				}
+				key := k[len(prefix):]
+				k, _ = c.Next()
+				return key
+			}
+		} else {
+			c := tx.Bucket(resultsBucket).Cursor()
+			k, _ := c.Seek(timePrefix(f.Since))
+			next = func() []byte {
+				key := k
+				k, _ = c.Next()
+				return key
+			}
+		}
+
+		bucket := tx.Bucket(resultsBucket)
+		for key := next(); key != nil; key = next() {
+			if !f.Until.IsZero() && bytes.Compare(key, timePrefix(f.Until)) >= 0 {
+				break
+			}
+			value := bucket.Get(key)
+			if value == nil {
+				return errors.New("missing result " + string(key) + " of the keywords index")
+			}
+			result := &scraper.Result{}
+			if err := json.Unmarshal(value, result); err != nil {
+				return err
+			}
+			if !f.match(result) {
+				continue
+			}
+			results = append(results, result)
+			if f.Limit > 0 && len(results) >= f.Limit {
+				break
+			}
+		}
+		return nil
+	})
+	return results, err
+}
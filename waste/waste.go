@@ -0,0 +1,141 @@
+// Package waste scores how much bidding on keywords cannibalises the organic
+// results of a domain: an ad is wasted when the organic result of the domain
+// is reached by the user without (or with few) competitors in between.
+package waste
+
+// Block of an ad on the page
+type Block string
+
+// Blocks of ads
+const (
+	Top    Block = "top"    // above the organic results
+	Bottom Block = "bottom" // below the organic results
+)
+
+// Reason explains a score
+type Reason string
+
+// Reasons of a score
+const (
+	NoAd          Reason = "no-ad"           // the domain has no ad
+	NoOrganic     Reason = "no-organic"      // the domain has no organic result
+	Adjacent      Reason = "adjacent"        // the ad is directly followed by the organic result
+	Siblings      Reason = "siblings"        // only siblings between the ad and the organic result
+	Competitors   Reason = "competitors"     // competitors between the ad and the organic result
+	OrganicTooLow Reason = "organic-too-low" // the organic result is below the maximum position
+)
+
+// Entry is an ad or an organic result of a page
+type Entry struct {
+	Domain   string
+	Position int   // position in its list, from 0
+	Block    Block // block of an ad, Top when empty
+}
+
+// SERP is a search result page: ads and organic results in page order
+type SERP struct {
+	Ads     []Entry
+	Organic []Entry
+}
+
+// Thresholds decide when a score is a waste
+type Thresholds struct {
+	MaxIntervening     int `json:"maxIntervening"`     // competitor results allowed between the ad and the organic result
+	MaxOrganicPosition int `json:"maxOrganicPosition"` // position of the organic result above which it is a waste, -1 for any
+}
+
+// DefaultThresholds match the historical rule: a waste only when nothing but
+// siblings separate the ad from the organic result
+var DefaultThresholds = Thresholds{MaxIntervening: 0, MaxOrganicPosition: -1}
+
+// Score of a domain on a page
+type Score struct {
+	Score       float64  `json:"score"`       // 1 when the ad is directly followed by the organic result, 1/(1+n) with n competitors in between, 0 without ad or organic result
+	Waste       bool     `json:"waste"`       // the score is a waste for the thresholds
+	Reason      Reason   `json:"reason"`      // explanation of the score
+	Block       Block    `json:"block"`       // block of the ad scored
+	Intervening []string `json:"intervening"` // competitor domains between the ad and the organic result, in page order
+}
+
+// Compute scores the ads of domain on a page. Siblings count as the domain.
+// A top ad is compared to the results below it down to the first organic
+// result of the domain; a bottom ad, seen after the organic results, to all
+// the results above the first organic result of the domain. The top ad is
+// scored when the domain has both
+func Compute(serp SERP, domain string, siblings []string, t Thresholds) Score {
+	ours := func(d string) bool {
+		if d == domain {
+			return true
+		}
+		for _, sibling := range siblings {
+			if d == sibling {
+				return true
+			}
+		}
+		return false
+	}
+
+	ad, block := -1, Block("")
+	for i, e := range serp.Ads {
+		if e.Domain != domain {
+			continue
+		}
+		b := e.Block
+		if b == "" {
+			b = Top
+		}
+		if ad < 0 || block == Bottom && b == Top {
+			ad, block = i, b
+		}
+	}
+	organic := -1
+	for i, e := range serp.Organic {
+		if e.Domain == domain {
+			organic = i
+			break
+		}
+	}
+	s := Score{Block: block, Intervening: make([]string, 0)}
+	switch {
+	case ad < 0:
+		s.Reason = NoAd
+		return s
+	case organic < 0:
+		s.Reason = NoOrganic
+		return s
+	}
+
+	// results between the ad and the organic result
+	between := make([]Entry, 0)
+	for i, e := range serp.Ads {
+		top := e.Block == "" || e.Block == Top
+		if top && (block == Bottom || i > ad) {
+			between = append(between, e)
+		}
+	}
+	between = append(between, serp.Organic[:organic]...)
+	sibling := false
+	for _, e := range between {
+		if ours(e.Domain) {
+			sibling = true
+			continue
+		}
+		s.Intervening = append(s.Intervening, e.Domain)
+	}
+
+	s.Score = 1 / float64(1+len(s.Intervening))
+	switch {
+	case len(s.Intervening) > 0:
+		s.Reason = Competitors
+	case sibling:
+		s.Reason = Siblings
+	default:
+		s.Reason = Adjacent
+	}
+	s.Waste = len(s.Intervening) <= t.MaxIntervening
+	if t.MaxOrganicPosition >= 0 && serp.Organic[organic].Position > t.MaxOrganicPosition {
+		s.Reason = OrganicTooLow
+		s.Waste = false
+	}
+	return s
+}
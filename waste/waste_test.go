@@ -0,0 +1,164 @@
+package waste
+
+import (
+	"reflect"
+	"testing"
+)
+
+// serp builds a page from the domains of its top ads and organic results
+func serp(ads []string, organic []string) SERP {
+	s := SERP{}
+	for i, d := range ads {
+		s.Ads = append(s.Ads, Entry{Domain: d, Position: i})
+	}
+	for i, d := range organic {
+		s.Organic = append(s.Organic, Entry{Domain: d, Position: i})
+	}
+	return s
+}
+
+const (
+	oui  = "www.oui.sncf"
+	sncf = "www.sncf.com"
+)
+
+var siblings = []string{sncf}
+
+func TestCompute(t *testing.T) {
+	bottom := serp([]string{"www.trainline.fr"}, []string{"www.trainline.fr", oui})
+	bottom.Ads = append(bottom.Ads, Entry{Domain: oui, Position: 1, Block: Bottom})
+	topAndBottom := serp([]string{oui, "www.trainline.fr"}, []string{oui})
+	topAndBottom.Ads = append(topAndBottom.Ads, Entry{Domain: oui, Position: 2, Block: Bottom})
+	bottomOnly := serp(nil, []string{oui})
+	bottomOnly.Ads = append(bottomOnly.Ads, Entry{Domain: oui, Position: 0, Block: Bottom})
+
+	tests := []struct {
+		name       string
+		serp       SERP
+		thresholds Thresholds
+		want       Score
+	}{
+		{
+			name: "adjacent",
+			serp: serp([]string{"www.trainline.fr", oui}, []string{oui, "www.trainline.fr"}),
+			want: Score{Score: 1, Waste: true, Reason: Adjacent, Block: Top, Intervening: []string{}},
+		},
+		{
+			name: "siblings only",
+			serp: serp([]string{oui, sncf}, []string{sncf, oui}),
+			want: Score{Score: 1, Waste: true, Reason: Siblings, Block: Top, Intervening: []string{}},
+		},
+		{
+			name: "competitors in between",
+			serp: serp([]string{oui, "www.trainline.fr"}, []string{"www.kayak.fr", sncf, oui}),
+			want: Score{Score: 1.0 / 3, Reason: Competitors, Block: Top, Intervening: []string{"www.trainline.fr", "www.kayak.fr"}},
+		},
+		{
+			name: "bottom ad after the organic results",
+			serp: bottom,
+			want: Score{Score: 1.0 / 3, Reason: Competitors, Block: Bottom, Intervening: []string{"www.trainline.fr", "www.trainline.fr"}},
+		},
+		{
+			name: "bottom ad with nothing above",
+			serp: bottomOnly,
+			want: Score{Score: 1, Waste: true, Reason: Adjacent, Block: Bottom, Intervening: []string{}},
+		},
+		{
+			name: "top ad scored rather than bottom ad",
+			serp: topAndBottom,
+			want: Score{Score: 0.5, Reason: Competitors, Block: Top, Intervening: []string{"www.trainline.fr"}},
+		},
+		{
+			name: "no ad",
+			serp: serp([]string{"www.trainline.fr"}, []string{oui}),
+			want: Score{Reason: NoAd, Intervening: []string{}},
+		},
+		{
+			name: "no organic",
+			serp: serp([]string{oui}, []string{"www.trainline.fr", sncf}),
+			want: Score{Reason: NoOrganic, Block: Top, Intervening: []string{}},
+		},
+		{
+			name:       "max intervening allows a competitor",
+			serp:       serp([]string{oui}, []string{"www.trainline.fr", oui}),
+			thresholds: Thresholds{MaxIntervening: 1, MaxOrganicPosition: -1},
+			want:       Score{Score: 0.5, Waste: true, Reason: Competitors, Block: Top, Intervening: []string{"www.trainline.fr"}},
+		},
+		{
+			name:       "max intervening exceeded",
+			serp:       serp([]string{oui}, []string{"www.trainline.fr", "www.kayak.fr", oui}),
+			thresholds: Thresholds{MaxIntervening: 1, MaxOrganicPosition: -1},
+			want:       Score{Score: 1.0 / 3, Reason: Competitors, Block: Top, Intervening: []string{"www.trainline.fr", "www.kayak.fr"}},
+		},
+		{
+			name:       "organic within max position",
+			serp:       serp([]string{oui}, []string{sncf, oui}),
+			thresholds: Thresholds{MaxIntervening: 0, MaxOrganicPosition: 1},
+			want:       Score{Score: 1, Waste: true, Reason: Siblings, Block: Top, Intervening: []string{}},
+		},
+		{
+			name:       "organic below max position",
+			serp:       serp([]string{oui}, []string{sncf, sncf, oui}),
+			thresholds: Thresholds{MaxIntervening: 0, MaxOrganicPosition: 1},
+			want:       Score{Score: 1, Reason: OrganicTooLow, Block: Top, Intervening: []string{}},
+		},
+	}
+	for _, test := range tests {
+		t.Run(test.name, func(t *testing.T) {
+			thresholds := test.thresholds
+			if thresholds == (Thresholds{}) {
+				thresholds = DefaultThresholds
+			}
+			got := Compute(test.serp, oui, siblings, thresholds)
+			if !reflect.DeepEqual(got, test.want) {
+				t.Errorf("Compute() = %+v, want %+v", got, test.want)
+			}
+		})
+	}
+}
+
+// ouiSpace is the historical waste rule: no result between the first ad and
+// the first organic result of oui.sncf, or only www.sncf.com as first
+// organic result
+func ouiSpace(s SERP) bool {
+	ad, organic := -1, -1
+	for i, e := range s.Ads {
+		if e.Domain == oui {
+			ad = i
+			break
+		}
+	}
+	for i, e := range s.Organic {
+		if e.Domain == oui {
+			organic = i
+			break
+		}
+	}
+	if ad < 0 || organic < 0 {
+		return false
+	}
+	space := len(s.Ads) - ad - 1 + organic
+	return space == 0 || space == 1 && s.Organic[0].Domain == sncf
+}
+
+// TestComputeDefaultThresholds checks that the waste flag of the default
+// thresholds matches the historical rule
+func TestComputeDefaultThresholds(t *testing.T) {
+	tests := []SERP{
+		serp([]string{oui}, []string{oui}),
+		serp([]string{"www.trainline.fr", oui}, []string{oui}),
+		serp([]string{oui}, []string{sncf, oui}),
+		serp([]string{oui}, []string{"www.trainline.fr", oui}),
+		serp([]string{oui, "www.trainline.fr"}, []string{oui}),
+		serp([]string{oui}, []string{sncf, "www.trainline.fr", oui}),
+		serp([]string{oui}, []string{"www.trainline.fr"}),
+		serp([]string{"www.trainline.fr"}, []string{oui}),
+		serp(nil, nil),
+	}
+	for _, s := range tests {
+		want := ouiSpace(s)
+		if got := Compute(s, oui, siblings, DefaultThresholds).Waste; got != want {
+			t.Errorf("Compute(%+v).Waste = %v, want %v", s, got, want)
+		}
+	}
+}